@@ -18,6 +18,7 @@ type config struct {
 	directory     string
 	output        string
 	decryptionKey string
+	split         bool
 }
 
 type variableFile struct {
@@ -33,6 +34,7 @@ func getconfig() (*config, error) {
 	pflag.StringVar(&cfg.directory, "directory", cfg.directory, "which directory to process")
 	pflag.StringVar(&cfg.output, "output", cfg.output, "which directory to write to")
 	pflag.StringVar(&cfg.decryptionKey, "decryption-key", cfg.decryptionKey, "decryption key for secrets")
+	pflag.BoolVar(&cfg.split, "split", cfg.split, "write every component to its own file in a directory per cluster, instead of one file per cluster")
 	pflag.BoolVar(&cfg.debug, "debug", cfg.debug, "enable debug output")
 	pflag.Parse()
 
@@ -147,6 +149,12 @@ func write(destination string, results []*variableFile) error {
 	}
 	defer file.Close()
 
+	enc := yamlv2.NewEncoder(file)
+	return enc.Encode(clusterValues(results))
+}
+
+// clusterValues returns the components of a cluster, together with its name.
+func clusterValues(results []*variableFile) map[string]interface{} {
 	values := concat(results)
 	clusterName := ""
 	for _, v := range results {
@@ -156,9 +164,30 @@ func write(destination string, results []*variableFile) error {
 		}
 	}
 	values["clusterName"] = clusterName
+	return values
+}
 
-	enc := yamlv2.NewEncoder(file)
-	return enc.Encode(values)
+// writeSplit writes every component to its own file in the cluster's directory,
+// so that naisplater merges them into the same variables as write would produce.
+func writeSplit(directory string, results []*variableFile) error {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	for component, contents := range clusterValues(results) {
+		destination := filepath.Join(directory, component+".yaml")
+		data, err := yamlv2.Marshal(map[string]interface{}{component: contents})
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(destination, data, 0644)
+		if err != nil {
+			return fmt.Errorf("write file: %w", err)
+		}
+	}
+
+	return nil
 }
 
 func run() error {
@@ -179,6 +208,13 @@ func run() error {
 	clusters := clusters(results)
 	for _, cluster := range clusters {
 		clusterResults := filter(cluster, results)
+		if cfg.split {
+			err = writeSplit(filepath.Join(cfg.output, cluster), clusterResults)
+			if err != nil {
+				return err
+			}
+			continue
+		}
 		destination := filepath.Join(cfg.output, cluster+".yaml")
 		err = write(destination, clusterResults)
 		if err != nil {
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nais/naisplater/pkg/diff"
+	"github.com/nais/naisplater/pkg/inventory"
+	"github.com/nais/naisplater/pkg/metadata"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const diffContext = 3
+
+// volatilePattern matches the lines of labels and annotations whose value uses touchedAt,
+// which changes on every run, so that they are ignored when comparing output.
+// It returns nil if there are no such labels or annotations.
+func volatilePattern(meta *metadata.Config) *regexp.Regexp {
+	if meta == nil {
+		return nil
+	}
+
+	keys := make([]string, 0)
+	for _, values := range []map[string]string{meta.Labels, meta.Annotations} {
+		for key, value := range values {
+			if strings.Contains(value, "touchedAt") {
+				keys = append(keys, regexp.QuoteMeta(key))
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+
+	// keys may be quoted, as in JSON output
+	return regexp.MustCompile(`(?m)^\s*["']?(` + strings.Join(keys, "|") + `)["']?\s*:.*(\n|$)`)
+}
+
+func ignoreVolatile(pattern *regexp.Regexp, data []byte) string {
+	if pattern == nil {
+		return string(data)
+	}
+	return pattern.ReplaceAllString(string(data), "")
+}
+
+// fileDiff returns a unified diff between two versions of a file.
+// Added and removed files are always reported, even if they are empty.
+func fileDiff(oldName, newName, oldText, newText string) string {
+	text := diff.Unified(oldName, newName, oldText, newText, diffContext)
+	if len(text) == 0 && (oldName == "/dev/null" || newName == "/dev/null") {
+		text = fmt.Sprintf("--- %s\n+++ %s\n", oldName, newName)
+	}
+	return text
+}
+
+// diffOutput writes a unified diff between the rendered templates and the files
+// currently in the output directory, and returns the number of changed files.
+// Files in the inventory of the output directory that are no longer rendered are shown as removed,
+// as they would be by --prune. Lines matched by the volatile pattern are ignored.
+func diffOutput(outputDirectory string, results []renderResult, volatile *regexp.Regexp, out io.Writer) (int, error) {
+	previous, err := inventory.Read(outputDirectory)
+	if err != nil {
+		return 0, err
+	}
+
+	changes := 0
+	files := make([]string, 0, len(results))
+
+	for _, result := range results {
+		files = append(files, result.filename)
+
+		oldName := result.output
+		oldData, err := os.ReadFile(result.output)
+		if os.IsNotExist(err) {
+			oldName = "/dev/null"
+		} else if err != nil {
+			return 0, err
+		}
+
+		text := fileDiff(oldName, result.output, ignoreVolatile(volatile, oldData), ignoreVolatile(volatile, result.data))
+		if len(text) == 0 {
+			continue
+		}
+
+		changes++
+		_, err = io.WriteString(out, text)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, file := range previous.Stale(inventory.New(files)) {
+		path := filepath.Join(outputDirectory, filepath.FromSlash(file))
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return 0, err
+		}
+
+		changes++
+		_, err = io.WriteString(out, fileDiff(path, "/dev/null", ignoreVolatile(volatile, data), ""))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return changes, nil
+}
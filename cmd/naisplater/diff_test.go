@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/nais/naisplater/pkg/metadata"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVolatilePattern(t *testing.T) {
+	assert.Nil(t, volatilePattern(nil))
+	assert.Nil(t, volatilePattern(&metadata.Config{Labels: map[string]string{"team": "{{ .team }}"}}))
+
+	pattern := volatilePattern(&metadata.Config{
+		Labels:      map[string]string{"team": "{{ .team }}", "example.com/touched-at": "{{ touchedAt }}"},
+		Annotations: map[string]string{"example.com/rendered": "at {{ touchedAt }}"},
+	})
+	assert.Equal(t, "metadata:\n  labels:\n    team: a\n    nais.io/touched-at: 1\n  annotations:\n",
+		ignoreVolatile(pattern, []byte("metadata:\n  labels:\n    team: a\n    nais.io/touched-at: 1\n    example.com/touched-at: 1\n  annotations:\n    example.com/rendered: at 1\n")))
+	assert.Equal(t, "{\"labels\": {\n  \"team\": \"a\"\n}}",
+		ignoreVolatile(pattern, []byte("{\"labels\": {\n  \"example.com/touched-at\": \"1\",\n  \"team\": \"a\"\n}}")))
+}
+
+func TestDiffOutput(t *testing.T) {
+	output := writeTemplates(t, map[string]string{
+		"app.yaml":              "metadata:\n  labels:\n    example.com/touched-at: 1\n    team: a\n",
+		"removed.yaml":          "kind: ConfigMap\n",
+		"manual.yaml":           "kind: Secret\n",
+		".naisplater-inventory": "files:\n- app.yaml\n- gone.yaml\n- removed.yaml\n",
+	})
+	pattern := volatilePattern(&metadata.Config{Labels: map[string]string{"example.com/touched-at": "{{ touchedAt }}"}})
+
+	results := []renderResult{{
+		filename: "app.yaml",
+		output:   filepath.Join(output, "app.yaml"),
+		data:     []byte("metadata:\n  labels:\n    example.com/touched-at: 2\n    team: a\n"),
+	}}
+	out := &bytes.Buffer{}
+	changes, err := diffOutput(output, results, pattern, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changes)
+	assert.Contains(t, out.String(), "--- "+filepath.Join(output, "removed.yaml")+"\n+++ /dev/null\n")
+	assert.NotContains(t, out.String(), "app.yaml")
+	assert.NotContains(t, out.String(), "manual.yaml")
+	assert.NotContains(t, out.String(), "gone.yaml")
+
+	out.Reset()
+	changes, err = diffOutput(output, results, nil, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changes)
+	assert.Contains(t, out.String(), "-    example.com/touched-at: 1\n+    example.com/touched-at: 2\n")
+}
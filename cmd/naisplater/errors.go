@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nais/naisplater/pkg/metadata"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// Number of rendered lines shown before and after the line of an error.
+const contextLines = 2
+
+// renderError is an error in the rendered output of a template, such as invalid YAML.
+// If the position of the error is known, it is mapped back to the template line that produced it.
+type renderError struct {
+	err      error
+	output   []byte
+	line     int
+	column   int
+	location string
+}
+
+func newRenderError(err error, output *sourceMap) *renderError {
+	e := &renderError{
+		err:    err,
+		output: output.Bytes(),
+	}
+
+	var position *metadata.Error
+	if errors.As(err, &position) {
+		e.line, e.column = position.Line, position.Column
+		e.location = output.location(e.offset())
+	}
+
+	return e
+}
+
+func (e *renderError) Error() string {
+	if len(e.location) == 0 {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%s (from template %s)", e.err, e.location)
+}
+
+func (e *renderError) Unwrap() error {
+	return e.err
+}
+
+// offset returns the byte offset of the error in the output. Without a column,
+// the first character on the line is used, as indentation often comes from a different template line.
+func (e *renderError) offset() int {
+	lines := strings.SplitAfter(string(e.output), "\n")
+	offset := 0
+	for i := 0; i < e.line-1 && i < len(lines); i++ {
+		offset += len(lines[i])
+	}
+	if e.line < 1 || e.line > len(lines) {
+		return offset
+	}
+
+	line := lines[e.line-1]
+	if e.column == 0 {
+		return offset + len(line) - len(strings.TrimLeft(line, " \t"))
+	}
+	column := 0
+	for i := range line {
+		if column++; column == e.column {
+			return offset + i
+		}
+	}
+	return offset + len(line)
+}
+
+// context returns the rendered lines around the error, with line numbers,
+// and a marker under the column if it is known.
+func (e *renderError) context() []string {
+	if e.line == 0 {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(e.output), "\n"), "\n")
+	first := e.line - contextLines
+	if first < 1 {
+		first = 1
+	}
+	last := e.line + contextLines
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(strconv.Itoa(last))
+
+	result := make([]string, 0, last-first+2)
+	for n := first; n <= last; n++ {
+		marker := " "
+		if n == e.line {
+			marker = ">"
+		}
+		result = append(result, fmt.Sprintf("%s %*d | %s", marker, width, n, lines[n-1]))
+		if n == e.line && e.column > 0 {
+			result = append(result, fmt.Sprintf("  %*s | %s^", width, "", indentation(lines[n-1], e.column-1)))
+		}
+	}
+
+	return result
+}
+
+// indentation returns whitespace as wide as the first characters of a line, keeping tabs.
+func indentation(line string, characters int) string {
+	buf := &strings.Builder{}
+	for _, c := range line {
+		if characters == 0 {
+			break
+		}
+		characters--
+		if c == '\t' {
+			buf.WriteRune('\t')
+		} else {
+			buf.WriteRune(' ')
+		}
+	}
+	return buf.String()
+}
+
+// logRenderError logs an error from rendering a template, with the rendered lines around it.
+// As the output may contain decrypted secrets, the complete output is only logged at debug level.
+func logRenderError(logger log.FieldLogger, path string, err error) {
+	logger.Errorf("Render %s: %s", path, err)
+
+	var renderErr *renderError
+	if !errors.As(err, &renderErr) {
+		return
+	}
+
+	for _, line := range renderErr.context() {
+		logger.Errorf("%s", line)
+	}
+
+	logger.Debugf("Rendered output of %s:", path)
+	lines := strings.Split(strings.TrimSuffix(string(renderErr.output), "\n"), "\n")
+	width := len(strconv.Itoa(len(lines)))
+	for i, line := range lines {
+		logger.Debugf("%*d | %s", width, i+1, line)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nais/naisplater/pkg/cryptutil"
+	"github.com/nais/naisplater/pkg/kubeschema"
+	"github.com/nais/naisplater/pkg/templatetools"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+// explain prints the value of a variable for a cluster, which file and line set it, and which values it overrides.
+// Without a path, every variable is explained. Encrypted values are only decrypted with --reveal.
+func explain(cfg *config, w io.Writer) error {
+	layers, err := readLayers(cfg)
+	if err != nil {
+		return err
+	}
+
+	groups, err := variableFiles(cfg, layers, cfg.cluster)
+	if err != nil {
+		return err
+	}
+
+	policy, err := readPolicy(cfg)
+	if err != nil {
+		return err
+	}
+
+	// decrypted values are escaped like when rendering, except in overrides, which are escaped when merged
+	var load, loadOverrides loadFunc = lintVariables, lintVariables
+	if cfg.reveal {
+		load = func(path string) (templatetools.Variables, templatetools.Rules, error) {
+			vars, rules, _, err := loadVariables(cfg, path, true, log.StandardLogger())
+			return vars, rules, err
+		}
+		loadOverrides = func(path string) (templatetools.Variables, templatetools.Rules, error) {
+			vars, rules, _, err := loadVariables(cfg, path, false, log.StandardLogger())
+			return vars, rules, err
+		}
+	}
+
+	overrides, err := readOverrides(cfg, loadOverrides)
+	if err != nil {
+		return err
+	}
+
+	provenance := templatetools.NewProvenance()
+	for _, paths := range groups {
+		for _, path := range paths {
+			err = provenance.AddFile(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	overridden := make(map[string]bool, len(overrides))
+	for _, o := range overrides {
+		overridden[o.source] = true
+		if len(o.path) > 0 {
+			err = provenance.AddFile(o.path)
+		} else {
+			err = provenance.AddVariables(o.source, o.vars)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	vars, err := explainVariables(groups, overrides, policy, load)
+	if err != nil {
+		return err
+	}
+
+	path := strings.Trim(cfg.explainPath, ".")
+	keys := make([]string, 0)
+	if len(path) > 0 {
+		keys = strings.Split(path, ".")
+	}
+
+	variables, ok := provenance.Variables(keys...)
+	if !ok {
+		return fmt.Errorf("%s is not set for cluster %s", path, cfg.cluster)
+	}
+
+	for _, variable := range variables {
+		if len(variable.Origins) == 0 {
+			continue
+		}
+		name := strings.Join(variable.Path, ".")
+		last := len(variable.Origins) - 1
+		value, err := originValue(cfg, variable.Origins[last])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// values merged with other strategies than replace, or with references, differ from the value set last
+		strategy := originStrategy(policy, name, variable.Origins[last])
+		switch {
+		case strategy == templatetools.Delete:
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+			fmt.Fprintf(w, "  deleted by %s\n", originLocation(variable.Origins[last]))
+		case variable.Origins[last].Encrypted:
+			fmt.Fprintf(w, "%s: %s\n", name, value)
+			fmt.Fprintf(w, "  set by %s\n", originLocation(variable.Origins[last]))
+		default:
+			data, err := json.Marshal(lookupVariable(vars, variable.Path))
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fmt.Fprintf(w, "%s: %s\n", name, data)
+			// overrides are not interpolated
+			if !overridden[variable.Origins[last].File] && len(templatetools.References(variable.Origins[last].Value)) > 0 {
+				fmt.Fprintf(w, "  interpolated from %s\n", value)
+			}
+			if len(strategy) > 0 && strategy != templatetools.Replace {
+				fmt.Fprintf(w, "  set by %s with strategy '%s': %s\n", originLocation(variable.Origins[last]), strategy, value)
+			} else {
+				fmt.Fprintf(w, "  set by %s\n", originLocation(variable.Origins[last]))
+			}
+		}
+		for i := last - 1; i >= 0; i-- {
+			value, err = originValue(cfg, variable.Origins[i])
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fmt.Fprintf(w, "  overrides %s: %s\n", originLocation(variable.Origins[i]), value)
+		}
+	}
+
+	return nil
+}
+
+// explainVariables merges and interpolates the variables of a cluster.
+func explainVariables(groups [][]string, overrides []override, policy *templatetools.Policy, load loadFunc) (templatetools.Variables, error) {
+	vars := templatetools.Variables{}
+	for _, paths := range groups {
+		layerVars, rules, err := mergeLayer(paths, load)
+		if err != nil {
+			return nil, err
+		}
+		err = policy.With(rules).MergeMaps(vars, layerVars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.Join(paths, ", "), err)
+		}
+	}
+
+	err := mergeOverrides(vars, overrides, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	err = templatetools.Interpolate(vars)
+	if err != nil {
+		return nil, fmt.Errorf("interpolate variables: %w", err)
+	}
+
+	return vars, nil
+}
+
+// lookupVariable returns the merged value of a variable.
+func lookupVariable(vars templatetools.Variables, path []string) interface{} {
+	var value interface{} = vars
+	for _, key := range path {
+		m, ok := value.(templatetools.Variables)
+		if !ok {
+			return nil
+		}
+		value = nil
+		for k, v := range m {
+			if fmt.Sprint(k) == key {
+				value = v
+				break
+			}
+		}
+	}
+	return kubeschema.JSONValue(value)
+}
+
+// originLocation returns the file and line of an origin, or only its source for variables set on the command line.
+func originLocation(origin templatetools.Origin) string {
+	if origin.Line == 0 {
+		return origin.File
+	}
+	return fmt.Sprintf("%s:%d", origin.File, origin.Line)
+}
+
+// originStrategy returns how the value of an origin is merged, set either by a tag or by the merge policy.
+func originStrategy(policy *templatetools.Policy, name string, origin templatetools.Origin) templatetools.Strategy {
+	if len(origin.Strategy) > 0 {
+		return origin.Strategy
+	}
+	return policy.Rules[name].Strategy
+}
+
+// originValue formats a value as compact JSON, so that its type is unambiguous.
+func originValue(cfg *config, origin templatetools.Origin) (string, error) {
+	value := origin.Value
+	if origin.Strategy == templatetools.Delete {
+		return "<deleted>", nil
+	}
+	if origin.Encrypted {
+		if !cfg.reveal {
+			return "<encrypted>", nil
+		}
+		plaintext, err := cryptutil.DecryptWithPassword(value.(string), cfg.decryptionKey)
+		if err != nil {
+			return "", err
+		}
+		value = plaintext
+	}
+
+	data, err := json.Marshal(kubeschema.JSONValue(value))
+	if err != nil {
+		return fmt.Sprint(value), nil
+	}
+	return string(data), nil
+}
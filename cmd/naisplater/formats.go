@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Output formats decide how a rendered template is post-processed.
+const (
+	formatYAML = "yaml" // labels and annotations are injected, and resources are validated
+	formatJSON = "json" // as YAML, but the syntax is checked and edits are made in JSON
+	formatRaw  = "raw"  // written verbatim
+)
+
+// Name of the files in template directories that override the output format of templates.
+const formatsFilename = ".naisplater.yaml"
+
+// formatRule assigns a format to templates matching a pattern.
+// Patterns without a slash match the file name, and other patterns match the path
+// relative to the directory of the formats file.
+type formatRule struct {
+	pattern string
+	format  string
+}
+
+// formats decides the output format of templates, using the closest formats file
+// in the template directory tree, and the file extension for templates without a matching rule.
+type formats struct {
+	root  string
+	rules map[string][]formatRule
+}
+
+// readFormats reads all formats files in the template directory.
+func readFormats(directory string) (*formats, error) {
+	f := &formats{
+		root:  filepath.Clean(directory),
+		rules: make(map[string][]formatRule),
+	}
+
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path != directory && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Name() != formatsFilename {
+			return nil
+		}
+		rules, err := readFormatRules(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		f.rules[filepath.Dir(filepath.Clean(path))] = rules
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func readFormatRules(path string) ([]formatRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Formats yaml.MapSlice `yaml:"formats"`
+	}
+	err = yaml.UnmarshalStrict(data, &file)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]formatRule, 0, len(file.Formats))
+	for _, item := range file.Formats {
+		rule := formatRule{
+			pattern: fmt.Sprint(item.Key),
+			format:  fmt.Sprint(item.Value),
+		}
+		_, err = filepath.Match(rule.pattern, "")
+		if err != nil {
+			return nil, fmt.Errorf("pattern '%s': %w", rule.pattern, err)
+		}
+		switch rule.format {
+		case formatYAML, formatJSON, formatRaw:
+		default:
+			return nil, fmt.Errorf("pattern '%s': unknown format '%s'; use %s, %s or %s", rule.pattern, rule.format, formatYAML, formatJSON, formatRaw)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// format returns the output format of a template, given by its path relative to the template directory.
+// The first matching rule in the closest formats file wins. Without a matching rule, the format is chosen by
+// file extension. As rules are found from the relative path, and not from where a cluster-specific template is,
+// a cluster-specific template gets the same format as the template it overrides.
+func (f *formats) format(relativePath string) string {
+	path := filepath.Join(f.root, relativePath)
+
+	for directory := filepath.Dir(path); ; directory = filepath.Dir(directory) {
+		for _, rule := range f.rules[directory] {
+			if rule.matches(directory, path) {
+				return rule.format
+			}
+		}
+		if directory == f.root || directory == filepath.Dir(directory) || !strings.HasPrefix(directory, f.root) {
+			break
+		}
+	}
+
+	return extensionFormat(path)
+}
+
+func (rule formatRule) matches(directory, path string) bool {
+	name := filepath.Base(path)
+	if strings.Contains(rule.pattern, "/") {
+		name, _ = filepath.Rel(directory, path)
+		name = filepath.ToSlash(name)
+	}
+	matched, _ := filepath.Match(rule.pattern, name)
+	return matched
+}
+
+// extensionFormat returns the default output format for a file name.
+func extensionFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".json":
+		return formatJSON
+	}
+	return formatRaw
+}
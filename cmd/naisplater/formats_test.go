@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormat(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		".naisplater.yaml":                          "formats:\n  \"manifests/*.conf\": yaml\n  \"*.tpl.txt\": raw\n",
+		"manifests/app.conf":                        "",
+		"manifests/.naisplater.yaml":                "formats:\n  \"*.json\": raw\n",
+		"clusters/prod-gcp/.naisplater.yaml":        "formats:\n  \"*.conf\": raw\n",
+		"clusters/prod-gcp/manifests/app.conf":      "",
+		"clusters/prod-gcp/manifests/settings.json": "",
+	})
+
+	f, err := readFormats(dir)
+	assert.NoError(t, err)
+
+	// templates are given by their relative path, so cluster-specific templates get the format of the
+	// template they override, and the rules next to them are not used
+	for path, format := range map[string]string{
+		"app.yaml":                formatYAML,
+		"app.json":                formatJSON,
+		"notes.tpl.txt":           formatRaw,
+		"script.sh":               formatRaw,
+		"manifests/app.conf":      formatYAML,
+		"manifests/settings.json": formatRaw,
+		"other/app.conf":          formatRaw,
+	} {
+		assert.Equal(t, format, f.format(path), path)
+	}
+}
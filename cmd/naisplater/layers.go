@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nais/naisplater/pkg/templatetools"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readLayers reads the parent layers of clusters from the variables directory.
+func readLayers(cfg *config) (templatetools.Layers, error) {
+	return templatetools.ReadLayers(filepath.Join(cfg.variables, templatetools.LayersFilename))
+}
+
+// isConfigFile returns whether a file in the variables directory configures how variables are merged,
+// instead of holding variables.
+func isConfigFile(name string) bool {
+	return name == templatetools.LayersFilename || name == templatetools.PolicyFilename
+}
+
+// readPolicy reads the merge rules for all variable files from the variables directory.
+func readPolicy(cfg *config) (*templatetools.Policy, error) {
+	return templatetools.ReadPolicy(filepath.Join(cfg.variables, templatetools.PolicyFilename))
+}
+
+// variableFiles returns the variable files of a cluster, grouped by layer in the order they are merged.
+func variableFiles(cfg *config, layers templatetools.Layers, cluster string) ([][]string, error) {
+	names, err := layers.Resolve(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([][]string, len(names))
+	for i, name := range names {
+		groups[i], err = layerFiles(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return groups, nil
+}
+
+// layerFiles returns the variable files of a layer or cluster. These are either a single '<name>.yaml' file,
+// or all YAML files in a '<name>' directory, in alphabetical order.
+func layerFiles(cfg *config, name string) ([]string, error) {
+	if name == templatetools.GlobalLayer {
+		name = ""
+	}
+	file := filepath.Join(cfg.variables, variablefilename(name))
+	dir := strings.TrimSuffix(file, ".yaml")
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return []string{file}, nil
+	}
+	if _, err := os.Stat(file); err == nil {
+		return nil, fmt.Errorf("both %s and %s exist; use only one of them", file, dir)
+	}
+
+	dirEntry, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read directory: %w", err)
+	}
+
+	files := make([]string, 0, len(dirEntry))
+	for _, entry := range dirEntry {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yaml") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+
+	return files, nil
+}
+
+// mergeLayer merges the variable files of a layer, and returns them with the merge rules set by tags in the files.
+// Files in the same layer directory can not set the same variables, as their order does not reflect which one
+// should override the other. For the same reason, a file can not delete a variable that another file sets.
+// Their rules are applied when the layer is merged on top of its parents.
+func mergeLayer(paths []string, load loadFunc) (templatetools.Variables, templatetools.Rules, error) {
+	result := templatetools.Variables{}
+	rules := templatetools.Rules{}
+	loaded := make([]templatetools.Variables, 0, len(paths))
+	loadedRules := make([]templatetools.Rules, 0, len(paths))
+
+	for i, path := range paths {
+		vars, fileRules, err := load(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for j := 0; j < i; j++ {
+			conflicts := templatetools.Conflicts(loaded[j], vars)
+			if len(conflicts) > 0 {
+				return nil, nil, fmt.Errorf("%s: %s is also set by %s", path, strings.Join(conflicts, ", "), paths[j])
+			}
+			conflicts = deleteConflicts(fileRules, loaded[j])
+			if len(conflicts) > 0 {
+				return nil, nil, fmt.Errorf("%s: deletes %s, which is set by %s", path, strings.Join(conflicts, ", "), paths[j])
+			}
+			conflicts = deleteConflicts(loadedRules[j], vars)
+			if len(conflicts) > 0 {
+				return nil, nil, fmt.Errorf("%s: %s is deleted by %s", path, strings.Join(conflicts, ", "), paths[j])
+			}
+		}
+		loaded = append(loaded, vars)
+		loadedRules = append(loadedRules, fileRules)
+
+		err = templatetools.MergeMaps(result, templatetools.Copy(vars))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+		rules = (&templatetools.Policy{Rules: rules}).With(fileRules).Rules
+	}
+
+	return result, rules, nil
+}
+
+// deleteConflicts returns the paths of variables that are deleted by rules, but set in vars, sorted.
+// Deleting a map conflicts with setting any variable within it.
+func deleteConflicts(rules templatetools.Rules, vars templatetools.Variables) []string {
+	conflicts := make([]string, 0)
+	for name, rule := range rules {
+		if rule.Strategy == templatetools.Delete && isSet(vars, strings.Split(name, ".")) {
+			conflicts = append(conflicts, name)
+		}
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
+
+// isSet returns whether a variable, or any variable within it, is set.
+func isSet(vars templatetools.Variables, path []string) bool {
+	var value interface{} = vars
+	for _, key := range path {
+		m, ok := value.(templatetools.Variables)
+		if !ok {
+			return false
+		}
+		found := false
+		for k, v := range m {
+			if fmt.Sprint(k) == key {
+				value, found = v, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// loadFunc reads a variable file, and returns its variables with the merge rules set by tags in the file.
+type loadFunc func(path string) (templatetools.Variables, templatetools.Rules, error)
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisplater/pkg/templatetools"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeVariables(t *testing.T, dir string, files map[string]string) []string {
+	paths := make([]string, 0, len(files))
+	for _, name := range []string{"a-team.yaml", "b-team.yaml"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(files[name]), 0644))
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestMergeLayer(t *testing.T) {
+	paths := writeVariables(t, t.TempDir(), map[string]string{
+		"a-team.yaml": "debug: !delete\nimage:\n  tag: !delete\n",
+		"b-team.yaml": "hosts: !append [b.example.com]\nimage:\n  repository: nginx\n",
+	})
+
+	vars, rules, err := mergeLayer(paths, lintVariables)
+	assert.NoError(t, err)
+	assert.Equal(t, templatetools.Variables{
+		"hosts": []interface{}{"b.example.com"},
+		"image": templatetools.Variables{"repository": "nginx"},
+	}, vars)
+	assert.Equal(t, templatetools.Rules{
+		"debug":     {Strategy: templatetools.Delete},
+		"image.tag": {Strategy: templatetools.Delete},
+		"hosts":     {Strategy: templatetools.Append},
+	}, rules)
+}
+
+func TestMergeLayerDeleteConflicts(t *testing.T) {
+	for _, test := range []struct {
+		a   string
+		b   string
+		err string
+	}{
+		{"debug: true\n", "debug: !delete\n", "b-team.yaml: deletes debug, which is set by a-team.yaml"},
+		{"debug: !delete\n", "debug: true\n", "b-team.yaml: debug is deleted by a-team.yaml"},
+		{"image: !delete\n", "image:\n  tag: \"1.21\"\n", "b-team.yaml: image is deleted by a-team.yaml"},
+		{"image:\n  tag: \"1.21\"\n", "image:\n  tag: !delete\n", "b-team.yaml: deletes image.tag, which is set by a-team.yaml"},
+	} {
+		dir := t.TempDir()
+		paths := writeVariables(t, dir, map[string]string{"a-team.yaml": test.a, "b-team.yaml": test.b})
+
+		_, _, err := mergeLayer(paths, lintVariables)
+		if assert.Error(t, err, test.b) {
+			assert.Equal(t, test.err, strings.Replace(err.Error(), dir+string(filepath.Separator), "", -1), test.b)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nais/naisplater/pkg/lint"
+	"github.com/nais/naisplater/pkg/templatefuncs"
+	"github.com/nais/naisplater/pkg/templatetools"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// undefinedReference is a reference that is undefined for some clusters.
+type undefinedReference struct {
+	location string
+	path     string
+}
+
+// lintTemplates analyzes the templates of every cluster without rendering them. References to variables that are
+// undefined for some clusters are reported as errors, and variables that no template reads as warnings.
+func lintTemplates(cfg *config) error {
+	layout, err := newTemplateLayout(cfg)
+	if err != nil {
+		return err
+	}
+
+	meta, err := metadataConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	clusters, err := selectedClusters(cfg)
+	if err != nil {
+		return err
+	}
+
+	layers, err := readLayers(cfg)
+	if err != nil {
+		return err
+	}
+
+	policy, err := readPolicy(cfg)
+	if err != nil {
+		return err
+	}
+
+	overrides, err := readOverrides(cfg, lintVariables)
+	if err != nil {
+		return err
+	}
+
+	errors := 0
+	undefined := make(map[undefinedReference][]string)
+	files := make(map[string]templatetools.Variables)
+	fileRules := make(map[string]templatetools.Rules)
+	uses := make(map[string]int)
+	unusedCounts := make(map[string]map[string]int)
+
+	for _, cluster := range clusters {
+		log.Infof("Linting templates for cluster '%s'", cluster)
+
+		groups, err := variableFiles(cfg, layers, cluster)
+		if err != nil {
+			return err
+		}
+		vars := templatetools.Variables{}
+		variablePaths := make([]string, 0)
+		for _, group := range groups {
+			layerVars, rules, err := mergeLayer(group, func(path string) (templatetools.Variables, templatetools.Rules, error) {
+				vars, ok := files[path]
+				if ok {
+					return vars, fileRules[path], nil
+				}
+				vars, rules, err := lintVariables(path)
+				if err != nil {
+					return nil, nil, err
+				}
+				files[path] = vars
+				fileRules[path] = rules
+				return vars, rules, nil
+			})
+			if err != nil {
+				return err
+			}
+			err = policy.With(rules).MergeMaps(vars, layerVars)
+			if err != nil {
+				return fmt.Errorf("%s: %w", strings.Join(group, ", "), err)
+			}
+			variablePaths = append(variablePaths, group...)
+		}
+
+		err = mergeOverrides(vars, overrides, policy)
+		if err != nil {
+			return err
+		}
+
+		usage := lint.NewUsage()
+		for _, path := range variablePaths {
+			for _, reference := range templatetools.References(files[path]) {
+				usage.Use(reference)
+			}
+		}
+		err = templatetools.Interpolate(vars)
+		if err != nil {
+			log.Errorf("cluster '%s': interpolate variables: %s", cluster, err)
+			errors++
+			continue
+		}
+
+		templates, err := layout.clusterTemplates(cluster, log.StandardLogger())
+		if err != nil {
+			return err
+		}
+		partials, filenames, err := splitPartials(templates)
+		if err != nil {
+			return err
+		}
+
+		// Map template names back to their paths. Templates are named by their relative path,
+		// and partials by their file name.
+		paths := make(map[string]string, len(templates))
+		for name, path := range templates {
+			paths[name] = path
+		}
+		for _, path := range partials {
+			paths[filepath.Base(path)] = path
+		}
+
+		for _, filename := range filenames {
+			tpl, err := parseTemplate(filename, templates[filename], partials)
+			if err != nil {
+				log.Errorf("%s", err)
+				errors++
+				continue
+			}
+			usage.Analyze(tpl)
+		}
+
+		if meta != nil {
+			errors += analyzeMetadata(usage, meta.Labels, "label")
+			errors += analyzeMetadata(usage, meta.Annotations, "annotation")
+		}
+
+		for _, r := range usage.Undefined(vars) {
+			location := r.Template
+			if path, ok := paths[r.Template]; ok {
+				location = path
+			}
+			key := undefinedReference{
+				location: fmt.Sprintf("%s:%d", location, r.Line),
+				path:     r.Path.String(),
+			}
+			list := undefined[key]
+			if len(list) == 0 || list[len(list)-1] != cluster {
+				undefined[key] = append(list, cluster)
+			}
+		}
+
+		// variables in files shared by several clusters are only unused if no cluster uses them
+		for _, path := range variablePaths {
+			uses[path]++
+			if unusedCounts[path] == nil {
+				unusedCounts[path] = make(map[string]int)
+			}
+			for _, variable := range usage.Unused(files[path]) {
+				unusedCounts[path][variable.String()]++
+			}
+		}
+	}
+
+	unusedFiles := make([]string, 0, len(unusedCounts))
+	for path := range unusedCounts {
+		unusedFiles = append(unusedFiles, path)
+	}
+	sort.Strings(unusedFiles)
+	for _, path := range unusedFiles {
+		unused := make([]string, 0)
+		for variable, count := range unusedCounts[path] {
+			if count == uses[path] {
+				unused = append(unused, variable)
+			}
+		}
+		sort.Strings(unused)
+		for _, variable := range unused {
+			log.Warnf("%s: %s is not used by any template", path, variable)
+		}
+	}
+
+	keys := make([]undefinedReference, 0, len(undefined))
+	for key := range undefined {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].location != keys[j].location {
+			return keys[i].location < keys[j].location
+		}
+		return keys[i].path < keys[j].path
+	})
+	for _, key := range keys {
+		which := "clusters " + strings.Join(undefined[key], ", ")
+		if len(undefined[key]) == len(clusters) && len(clusters) > 1 {
+			which = "all clusters"
+		} else if len(undefined[key]) == 1 {
+			which = "cluster " + undefined[key][0]
+		}
+		log.Errorf("%s: %s is undefined for %s", key.location, key.path, which)
+		errors++
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("encountered %d errors; see log", errors)
+	}
+
+	log.Infof("No undefined variables found")
+
+	return nil
+}
+
+// lintVariables reads a variable file without decrypting it. Encrypted variables
+// are renamed like when they are decrypted, but keep their encrypted values.
+func lintVariables(path string) (templatetools.Variables, templatetools.Rules, error) {
+	vars, rules, err := templatetools.ReadVariables(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = templatetools.CryptTransform(vars, "", func(source, key string) (string, error) {
+		return source, nil
+	}, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return vars, rules, nil
+}
+
+// analyzeMetadata adds the references of label or annotation templates to the usage.
+func analyzeMetadata(usage *lint.Usage, values map[string]string, kind string) int {
+	funcs := templatefuncs.FuncMap()
+	funcs["touchedAt"] = func() string {
+		return ""
+	}
+
+	errors := 0
+	for key, value := range values {
+		tpl, err := template.New(fmt.Sprintf("%s '%s'", kind, key)).Funcs(funcs).Parse(value)
+		if err != nil {
+			log.Errorf("%s '%s': %s", kind, key, err)
+			errors++
+			continue
+		}
+		usage.Analyze(tpl)
+	}
+	return errors
+}
@@ -1,34 +1,64 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/nais/naisplater/pkg/cryptutil"
+	"github.com/nais/naisplater/pkg/report"
 	"github.com/nais/naisplater/pkg/templatetools"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v2"
-	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
-	"text/template"
 	"time"
 )
 
+const allClustersKeyword = "all"
+
+// Output "directory" for writing all rendered templates to stdout.
+const streamOutput = "-"
+
+// Exit status used by --diff when the rendered output differs from the output directory.
+const exitChanges = 2
+
+var errChanges = fmt.Errorf("rendered output differs from output directory")
+
 type config struct {
-	debug         bool
-	encrypt       bool
-	decrypt       string
-	templates     string
-	variables     string
-	output        string
-	cluster       string
-	decryptionKey string
-	addLabels     bool
-	touchedAt     string
-	validate      bool
+	lint             bool
+	explain          bool
+	explainPath      string
+	reveal           bool
+	debug            bool
+	encrypt          bool
+	decrypt          string
+	templates        string
+	variables        string
+	output           string
+	cluster          string
+	clusters         []string
+	decryptionKey    string
+	addLabels        bool
+	metadataConfig   string
+	labels           []string
+	annotations      []string
+	touchedAt        string
+	validate         bool
+	validateSchemas  bool
+	schemas          string
+	diff             bool
+	prune            bool
+	pruneDryRun      bool
+	jobs             int
+	reportFormat     string
+	reportFile       string
+	set              []string
+	setFiles         []string
+	values           []string
+	variablesFromEnv bool
+	variablesSchema  string
 }
 
 func getconfig() (*config, error) {
@@ -36,33 +66,118 @@ func getconfig() (*config, error) {
 	touchedAt := currentTime.Format("20060102T150405")
 
 	cfg := &config{
-		addLabels:     true,
-		touchedAt:     touchedAt,
-		decryptionKey: os.Getenv("NAISPLATER_DECRYPTION_KEY"),
+		addLabels:       true,
+		validateSchemas: true,
+		touchedAt:       touchedAt,
+		decryptionKey:   os.Getenv("NAISPLATER_DECRYPTION_KEY"),
 	}
 
 	pflag.StringVar(&cfg.templates, "templates", cfg.templates, "directory with templates")
 	pflag.StringVar(&cfg.variables, "variables", cfg.variables, "directory with variables")
-	pflag.StringVar(&cfg.output, "output", cfg.output, "which directory to write to")
-	pflag.StringVar(&cfg.cluster, "cluster", cfg.cluster, "cluster for rendering templates and variables")
+	pflag.StringVar(&cfg.output, "output", cfg.output, "which directory to write to; use '-' to write all templates to STDOUT as one YAML stream")
+	pflag.StringVar(&cfg.cluster, "cluster", cfg.cluster, "cluster for rendering templates and variables; use 'all' to render every cluster into per-cluster output directories")
+	pflag.StringSliceVar(&cfg.clusters, "clusters", cfg.clusters, "comma-separated list of clusters to render into per-cluster output directories")
 	pflag.StringVar(&cfg.decryptionKey, "decryption-key", cfg.decryptionKey, "key for decrypting variables ($NAISPLATER_DECRYPTION_KEY)")
-	pflag.BoolVar(&cfg.debug, "debug", cfg.debug, "enable debug output")
-	pflag.BoolVar(&cfg.addLabels, "add-labels", cfg.addLabels, "add 'nais.io/created-by' and 'nais.io/touched-at' labels")
-	pflag.StringVar(&cfg.touchedAt, "touched-at", cfg.touchedAt, "use custom timestamp in 'nais.io/touched-at' label")
+	pflag.BoolVar(&cfg.debug, "debug", cfg.debug, "enable debug output, including the rendered output of templates that fail")
+	pflag.BoolVar(&cfg.addLabels, "add-labels", cfg.addLabels, "add labels and annotations to every resource; defaults to 'nais.io/created-by' and 'nais.io/touched-at' labels")
+	pflag.StringVar(&cfg.metadataConfig, "metadata-config", cfg.metadataConfig, "file with labels and annotations to add, instead of the default labels")
+	pflag.StringArrayVar(&cfg.labels, "label", cfg.labels, "add label 'key=value' to every resource; value can be a template (repeatable)")
+	pflag.StringArrayVar(&cfg.annotations, "annotation", cfg.annotations, "add annotation 'key=value' to every resource; value can be a template (repeatable)")
+	pflag.StringVar(&cfg.touchedAt, "touched-at", cfg.touchedAt, "use custom timestamp in 'nais.io/touched-at' label, available as '{{ touchedAt }}' in label templates")
 	pflag.BoolVar(&cfg.encrypt, "encrypt", cfg.encrypt, "in-place encrypt all plaintext values with 'key.enc' keys")
 	pflag.StringVar(&cfg.decrypt, "decrypt", cfg.decrypt, "decrypt all ciphertext values with 'key.enc' keys in given file; output the whole file to STDOUT")
 	pflag.BoolVar(&cfg.validate, "validate", cfg.validate, "render all templates for all clusters in-memory and check for syntax/runtime errors")
+	pflag.BoolVar(&cfg.validateSchemas, "validate-schemas", cfg.validateSchemas, "in --validate mode, also validate rendered resources against bundled Kubernetes schemas and --schemas")
+	pflag.StringVar(&cfg.schemas, "schemas", cfg.schemas, "directory with additional JSON schemas and CustomResourceDefinitions for --validate")
+	pflag.BoolVar(&cfg.diff, "diff", cfg.diff, "render in-memory and show differences from the files in --output; exits with status 2 if there are changes")
+	pflag.BoolVar(&cfg.prune, "prune", cfg.prune, "remove previously generated files from --output that are no longer generated")
+	pflag.BoolVar(&cfg.pruneDryRun, "prune-dry-run", cfg.pruneDryRun, "list files that would be removed by --prune")
+	pflag.IntVar(&cfg.jobs, "jobs", cfg.jobs, "maximum number of clusters and templates to render concurrently; defaults to the number of CPUs")
+	pflag.StringVar(&cfg.reportFormat, "report-format", cfg.reportFormat, "write a report with the result of every cluster and template, as 'json' or 'junit' XML")
+	pflag.StringVar(&cfg.reportFile, "report-file", cfg.reportFile, "file to write the report to, instead of STDOUT; implies --report-format=json")
+	pflag.StringArrayVar(&cfg.set, "set", cfg.set, "set variable 'path.to.key=value' for every cluster, overriding variable files; values are strings (repeatable)")
+	pflag.StringArrayVar(&cfg.setFiles, "set-file", cfg.setFiles, "set variable 'path.to.key=file' to the contents of a file for every cluster (repeatable)")
+	pflag.StringArrayVar(&cfg.values, "values", cfg.values, "merge variables from a YAML file on top of the variables of every cluster (repeatable)")
+	pflag.BoolVar(&cfg.variablesFromEnv, "variables-from-env", cfg.variablesFromEnv, "set variables from $NAISPLATER_VAR_<key> environment variables, with '__' separating the keys of a path")
+	pflag.StringVar(&cfg.variablesSchema, "variables-schema", cfg.variablesSchema, "JSON schema, written as JSON or YAML, that the merged variables of every cluster must match")
+	pflag.BoolVar(&cfg.reveal, "reveal", cfg.reveal, "in explain mode, show decrypted values of encrypted variables")
 	pflag.Parse()
 
+	// not set as the flag default, so that the help text is the same on every machine
+	if !pflag.CommandLine.Changed("jobs") {
+		cfg.jobs = runtime.NumCPU()
+	}
+
+	switch pflag.Arg(0) {
+	case "":
+	case "lint":
+		cfg.lint = true
+	case "explain":
+		cfg.explain = true
+		cfg.explainPath = pflag.Arg(1)
+	default:
+		return nil, fmt.Errorf("unknown command '%s'", pflag.Arg(0))
+	}
+	arguments := 1
+	if cfg.explain {
+		arguments = 2
+	}
+	if pflag.NArg() > arguments {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(pflag.Args()[arguments:], " "))
+	}
+	if cfg.reveal && !cfg.explain {
+		return nil, fmt.Errorf("--reveal can only be used together with explain")
+	}
+	if (len(cfg.set) > 0 || len(cfg.setFiles) > 0 || len(cfg.values) > 0 || cfg.variablesFromEnv) && (cfg.encrypt || len(cfg.decrypt) > 0) {
+		return nil, fmt.Errorf("--set, --set-file, --values and --variables-from-env cannot be used together with --encrypt or --decrypt")
+	}
+	if !cfg.addLabels && (len(cfg.metadataConfig) > 0 || len(cfg.labels) > 0 || len(cfg.annotations) > 0) {
+		return nil, fmt.Errorf("--metadata-config, --label and --annotation cannot be used together with --add-labels=false")
+	}
+	if len(cfg.variablesSchema) > 0 && (cfg.encrypt || len(cfg.decrypt) > 0 || cfg.lint || cfg.explain) {
+		return nil, fmt.Errorf("--variables-schema can only be used when rendering or validating")
+	}
+	if cfg.explain {
+		// only variables are needed to explain them
+		if len(cfg.variables) == 0 {
+			return nil, fmt.Errorf("--variables required")
+		}
+		if len(cfg.cluster) == 0 || cfg.cluster == allClustersKeyword || len(cfg.clusters) > 0 {
+			return nil, fmt.Errorf("explain needs a single --cluster")
+		}
+		if cfg.validate || cfg.diff || cfg.encrypt || len(cfg.decrypt) > 0 || len(cfg.reportFormat) > 0 || len(cfg.reportFile) > 0 {
+			return nil, fmt.Errorf("explain cannot be used together with --validate, --diff, --encrypt, --decrypt or reports")
+		}
+		if cfg.reveal && len(cfg.decryptionKey) == 0 {
+			return nil, fmt.Errorf("--reveal needs --decryption-key to work")
+		}
+		return cfg, nil
+	}
+	if cfg.lint && (cfg.validate || cfg.diff || cfg.encrypt || len(cfg.decrypt) > 0 || len(cfg.reportFormat) > 0 || len(cfg.reportFile) > 0) {
+		return nil, fmt.Errorf("lint cannot be used together with --validate, --diff, --encrypt, --decrypt or reports")
+	}
+
 	if len(cfg.decrypt) == 0 && len(cfg.variables) == 0 {
 		return nil, fmt.Errorf("--variables required")
 	}
 	if cfg.validate && (cfg.encrypt || len(cfg.decrypt) > 0) {
 		return nil, fmt.Errorf("--validate cannot be used together with --encrypt or --decrypt")
 	}
+	if cfg.diff && (cfg.validate || cfg.encrypt || len(cfg.decrypt) > 0) {
+		return nil, fmt.Errorf("--diff cannot be used together with --validate, --encrypt or --decrypt")
+	}
 	if cfg.encrypt && len(cfg.decrypt) > 0 {
 		return nil, fmt.Errorf("--encrypt and --decrypt are mutually exclusive")
 	}
+	if len(cfg.reportFile) > 0 && len(cfg.reportFormat) == 0 {
+		cfg.reportFormat = report.JSON
+	}
+	if len(cfg.reportFormat) > 0 && (cfg.encrypt || len(cfg.decrypt) > 0) {
+		return nil, fmt.Errorf("--report-format and --report-file cannot be used together with --encrypt or --decrypt")
+	}
+	if len(cfg.reportFormat) > 0 && cfg.reportFormat != report.JSON && cfg.reportFormat != report.JUnit {
+		return nil, fmt.Errorf("--report-format must be '%s' or '%s'", report.JSON, report.JUnit)
+	}
 	if cfg.encrypt || len(cfg.decrypt) > 0 {
 		if len(cfg.decryptionKey) == 0 {
 			return nil, fmt.Errorf("--encrypt and --decrypt needs --decryption-key to work")
@@ -70,116 +185,85 @@ func getconfig() (*config, error) {
 		// return early for crypt-only operation
 		return cfg, nil
 	}
+	if cfg.jobs < 1 {
+		return nil, fmt.Errorf("--jobs must be at least 1")
+	}
 	if len(cfg.templates) == 0 {
 		return nil, fmt.Errorf("--templates required")
 	}
+	if len(cfg.schemas) > 0 && !cfg.validate {
+		return nil, fmt.Errorf("--schemas can only be used together with --validate")
+	}
 	if cfg.validate {
 		// no --output or --cluster required for validation
 		return cfg, nil
 	}
-	if len(cfg.cluster) == 0 {
-		return nil, fmt.Errorf("--cluster required")
+	if cfg.lint {
+		// all clusters are linted unless --cluster or --clusters is given
+		if len(cfg.cluster) > 0 && len(cfg.clusters) > 0 {
+			return nil, fmt.Errorf("--cluster and --clusters are mutually exclusive")
+		}
+		return cfg, nil
+	}
+	if len(cfg.cluster) > 0 && len(cfg.clusters) > 0 {
+		return nil, fmt.Errorf("--cluster and --clusters are mutually exclusive")
+	}
+	if len(cfg.cluster) == 0 && len(cfg.clusters) == 0 {
+		return nil, fmt.Errorf("--cluster or --clusters required")
 	}
 	if len(cfg.output) == 0 {
 		return nil, fmt.Errorf("--output required")
 	}
-
-	return cfg, nil
-}
-
-func render(inFile, outFile string, vars templatetools.Variables, cfg *config) error {
-	out, err := os.OpenFile(outFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	if cfg.diff && cfg.stream() {
+		return nil, fmt.Errorf("--diff needs an output directory to compare with")
 	}
-	defer out.Close()
-
-	tpl := template.New(filepath.Base(inFile))
-
-	// Register helper functions
-	tpl = tpl.Funcs(template.FuncMap{
-		"Join": strings.Join,
-		"FlattenMap": func(vars templatetools.Variables) []string {
-			result := make([]string, 0, len(vars))
-			for _, value := range vars {
-				result = append(result, fmt.Sprintf("%s", value))
-			}
-			return result
-		},
-	})
-
-	tpl, err = tpl.ParseFiles(inFile)
-	if err != nil {
-		return err
+	if len(cfg.reportFormat) > 0 && (cfg.diff || cfg.stream()) && (len(cfg.reportFile) == 0 || cfg.reportFile == streamOutput) {
+		return nil, fmt.Errorf("--report-file is required with --diff or --output '-', as they write to STDOUT")
 	}
-
-	// Nice API. Fail on undefined template variables.
-	tpl.Option("missingkey=error")
-
-	log.Debugf("Rendering %s to %s", inFile, outFile)
-
-	buffer := &bytes.Buffer{}
-	err = tpl.Execute(buffer, vars)
-	if err != nil {
-		return err
+	if (cfg.prune || cfg.pruneDryRun) && !cfg.writeFiles() {
+		return nil, fmt.Errorf("--prune and --prune-dry-run can only be used when writing to an output directory")
 	}
-
-	if !cfg.addLabels {
-		_, err = io.Copy(out, buffer)
-		return err
+	if cfg.prune && cfg.pruneDryRun {
+		return nil, fmt.Errorf("--prune and --prune-dry-run are mutually exclusive")
 	}
 
-	decoder := yaml.NewDecoder(buffer)
-	encoder := yaml.NewEncoder(out)
-
-	defer encoder.Close()
+	return cfg, nil
+}
 
-	bufbytes := buffer.Bytes()
+func (cfg *config) multiCluster() bool {
+	return cfg.cluster == allClustersKeyword || len(cfg.clusters) > 0
+}
 
-	for {
-		content := make(map[interface{}]interface{})
-		err = decoder.Decode(&content)
-		if err == io.EOF {
-			encoder.Close()
-			out.Close()
-			return nil
-		} else if err != nil {
-			os.Stdout.Write([]byte("\n\n-----------------------\n\n"))
-			os.Stdout.Write(bufbytes)
-			return err
-		}
+// stream returns true if rendered templates should be written to stdout.
+func (cfg *config) stream() bool {
+	return cfg.output == streamOutput
+}
 
-		err = injectLabels(content, cfg.touchedAt)
-		if err != nil {
-			return err
-		}
+// writeFiles returns true if rendered templates should be written to the output directory.
+func (cfg *config) writeFiles() bool {
+	return !cfg.validate && !cfg.diff && !cfg.stream()
+}
 
-		err = encoder.Encode(content)
+// keyValues parses a list of 'key=value' flag values.
+func keyValues(flag string, values []string) (map[string]string, error) {
+	result := make(map[string]string, len(values))
+	for _, value := range values {
+		key, value, err := keyValue(flag, value)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		result[key] = value
 	}
-
-	return nil
+	return result, nil
 }
 
-func injectLabels(content map[interface{}]interface{}, touchedAt string) error {
-
-	metadata, ok := content["metadata"].(map[interface{}]interface{})
-	if !ok {
-		return nil
-	}
-
-	labels, ok := metadata["labels"].(map[interface{}]interface{})
-	if !ok {
-		metadata["labels"] = make(map[interface{}]interface{})
-		labels = metadata["labels"].(map[interface{}]interface{})
+// keyValue parses a single 'key=value' flag value.
+func keyValue(flag, value string) (string, string, error) {
+	i := strings.Index(value, "=")
+	if i < 1 {
+		return "", "", fmt.Errorf("%s: expected 'key=value', got '%s'", flag, value)
 	}
-
-	labels["nais.io/created-by"] = "nais-yaml"
-	labels["nais.io/touched-at"] = touchedAt
-
-	return nil
+	return value[:i], value[i+1:], nil
 }
 
 func variablefilename(cluster string) string {
@@ -189,48 +273,61 @@ func variablefilename(cluster string) string {
 	return cluster + ".yaml"
 }
 
-func merge(dst, src map[string]string) {
-	for k, v := range src {
-		dst[k] = v
+func allClusters(cfg *config) ([]string, error) {
+	names, err := variableNames(cfg)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func directoryTemplates(directory string) (map[string]string, error) {
-	entries, err := os.ReadDir(directory)
+	layers, err := readLayers(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	files := make(map[string]string)
-
-	for _, entry := range entries {
-		if entry.IsDir() {
+	clusters := make([]string, 0, len(names))
+	for _, name := range names {
+		// layers are only merged into clusters, and never rendered on their own
+		if layers.IsLayer(name) {
 			continue
 		}
-		basename := entry.Name()
-		files[basename] = filepath.Join(directory, basename)
+		clusters = append(clusters, name)
 	}
 
-	return files, nil
+	return clusters, nil
 }
 
-func allClusters(cfg *config) ([]string, error) {
+// variableNames returns the names of all variable files and directories in the variables directory,
+// both clusters and layers, sorted.
+func variableNames(cfg *config) ([]string, error) {
 	dirEntry, err := os.ReadDir(cfg.variables)
 	if err != nil {
 		return nil, fmt.Errorf("read directory: %w", err)
 	}
 
-	clusters := make([]string, 0)
+	names := make([]string, 0)
+	seen := make(map[string]bool)
 	for _, file := range dirEntry {
-		if file.IsDir() {
+		if isConfigFile(file.Name()) || strings.HasPrefix(file.Name(), ".") {
 			continue
 		}
-		if strings.HasSuffix(file.Name(), ".yaml") {
-			clusters = append(clusters, file.Name()[:len(file.Name())-5])
+		name := file.Name()
+		if !file.IsDir() {
+			if !strings.HasSuffix(name, ".yaml") {
+				continue
+			}
+			name = name[:len(name)-5]
+		}
+		// a cluster with both a file and a directory is reported when its variables are read
+		if seen[name] {
+			continue
 		}
+		seen[name] = true
+		names = append(names, name)
 	}
 
-	return clusters, nil
+	sort.Strings(names)
+
+	return names, nil
 }
 
 func encrypt(cfg *config) error {
@@ -239,21 +336,42 @@ func encrypt(cfg *config) error {
 		return fmt.Errorf("read directory: %w", err)
 	}
 
+	paths := make([]string, 0, len(dirEntry))
 	for _, file := range dirEntry {
-		if file.IsDir() {
+		if isConfigFile(file.Name()) {
 			continue
 		}
+		if !file.IsDir() {
+			paths = append(paths, filepath.Join(cfg.variables, file.Name()))
+			continue
+		}
+		if strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+		// variables of a cluster or layer can be split across files in a directory
+		files, err := layerFiles(cfg, file.Name())
+		if err != nil {
+			return err
+		}
+		paths = append(paths, files...)
+	}
 
-		path := filepath.Join(cfg.variables, file.Name())
-		vars, err := templatetools.VariablesFromFiles(path)
+	// files are checked before any is rewritten, so that a file with merge tags leaves all files untouched
+	files := make([]templatetools.Variables, len(paths))
+	for i, path := range paths {
+		files[i], err = untaggedVariables(path)
 		if err != nil {
-			return fmt.Errorf("%s: %w", path, err)
+			return err
 		}
+	}
+
+	for i, path := range paths {
+		vars := files[i]
 		err = templatetools.CryptTransform(vars, cfg.decryptionKey, cryptutil.EncryptIfPlaintext, false)
 		if err != nil {
 			return fmt.Errorf("%s: %w", path, err)
 		}
-		tmpfile, err := os.CreateTemp(cfg.variables, ".naisplater")
+		tmpfile, err := os.CreateTemp(filepath.Dir(path), ".naisplater")
 		if err != nil {
 			return err
 		}
@@ -275,7 +393,7 @@ func encrypt(cfg *config) error {
 }
 
 func decrypt(cfg *config) error {
-	vars, err := templatetools.VariablesFromFiles(cfg.decrypt)
+	vars, err := untaggedVariables(cfg.decrypt)
 	if err != nil {
 		return err
 	}
@@ -288,118 +406,81 @@ func decrypt(cfg *config) error {
 	return yaml.NewEncoder(os.Stdout).Encode(vars)
 }
 
-func run(cfg *config) error {
-	errors := 0
-
-	globals := filepath.Join(cfg.variables, variablefilename(""))
-	locals := filepath.Join(cfg.variables, variablefilename(cfg.cluster))
-
-	log.Debugf("Using global variables from %s", globals)
-	log.Debugf("Using cluster-override variables from %s", locals)
-
-	vars, err := templatetools.VariablesFromFiles(globals, locals)
+// untaggedVariables reads a variable file for --encrypt and --decrypt, which write its variables without tags.
+// Files with merge tags are rejected, as the tags would be lost.
+func untaggedVariables(path string) (templatetools.Variables, error) {
+	vars, rules, err := templatetools.ReadVariables(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if len(rules) > 0 {
+		return nil, fmt.Errorf("%s: files with merge tags can not be encrypted or decrypted, as the tags would be lost", path)
+	}
+	return vars, nil
+}
 
-	log.Debugf("Decrypting variables")
-	err = templatetools.CryptTransform(vars, cfg.decryptionKey, cryptutil.DecryptWithPassword, true)
-	if err != nil {
-		if len(cfg.decryptionKey) == 0 {
-			log.Errorf("decrypt variable: %s", err)
-			log.Warnf("Decryption key is missing; skipping all variable decryption")
-			errors++
-		} else {
-			return err
-		}
+func selectedClusters(cfg *config) ([]string, error) {
+	if cfg.validate || cfg.cluster == allClustersKeyword {
+		return allClusters(cfg)
 	}
+	if cfg.lint && len(cfg.cluster) == 0 && len(cfg.clusters) == 0 {
+		return allClusters(cfg)
+	}
+	if len(cfg.cluster) > 0 {
+		return []string{cfg.cluster}, nil
+	}
+	return cfg.clusters, nil
+}
 
-	log.Debugf("Using templates from %s", cfg.templates)
+func run(cfg *config) error {
+	start := time.Now()
 
-	templates, err := directoryTemplates(cfg.templates)
+	r, err := newRenderer(cfg)
 	if err != nil {
 		return err
 	}
 
-	clusterTemplates := filepath.Join(cfg.templates, cfg.cluster)
-	overrides, err := directoryTemplates(clusterTemplates)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Debugf("No cluster-specific template directory for '%s'", cfg.cluster)
-		} else {
-			return err
-		}
+	if !cfg.multiCluster() {
+		err = r.renderCluster(cfg.cluster, cfg.output, log.StandardLogger(), os.Stdout)
 	} else {
-		log.Debugf("Using cluster-override templates from %s", clusterTemplates)
-	}
-
-	merge(templates, overrides)
-
-	filenames := make([]string, 0, len(templates))
-	for k := range templates {
-		filenames = append(filenames, k)
-	}
-	sort.Strings(filenames)
-
-	if !cfg.validate {
-		log.Debugf("Using output directory %s", cfg.output)
-		err = os.MkdirAll(cfg.output, 0755)
-		if err != nil {
-			return err
+		var clusters []string
+		clusters, err = selectedClusters(cfg)
+		if err == nil {
+			err = r.renderClusters(clusters)
 		}
 	}
 
-	for _, filename := range filenames {
-		path := templates[filename]
-		output := filepath.Join(cfg.output, filename)
-		if cfg.validate {
-			output = "/dev/null"
-		}
-		err = render(path, output, vars, cfg)
-		if err != nil {
-			errors++
-			log.Errorf("Render %s: %s", path, err)
-		} else {
-			log.Debugf("Rendered %s", output)
-		}
-	}
+	err = finishReport(r, err, time.Since(start))
 
-	if errors > 0 {
-		return fmt.Errorf("encountered %d errors; see log", errors)
+	if err == nil && r.changes > 0 {
+		return errChanges
 	}
 
-	return nil
+	return err
 }
 
 func validate(cfg *config) error {
-	clusters, err := allClusters(cfg)
+	start := time.Now()
+
+	r, err := newRenderer(cfg)
 	if err != nil {
 		return err
 	}
 
-	errors := 0
-	for _, cluster := range clusters {
-		log.Infof("Running validation for cluster '%s'", cluster)
-
-		cfg.cluster = cluster
-		err = run(cfg)
-
-		if err != nil {
-			log.Errorf("Validation failed for cluster '%s': %s", cluster, err)
-			errors++
-		}
-	}
-
-	if errors > 0 {
-		return fmt.Errorf("%d clusters failed validation", errors)
+	clusters, err := selectedClusters(cfg)
+	if err != nil {
+		return err
 	}
 
-	log.Infof("All clusters rendered successfully")
+	err = r.renderClusters(clusters)
 
-	return nil
+	return finishReport(r, err, time.Since(start))
 }
 
 func runner() error {
+	// STDOUT is reserved for rendered output, diffs and decrypted files.
+	log.SetOutput(os.Stderr)
+
 	cfg, err := getconfig()
 	if err != nil {
 		return fmt.Errorf("configuration error: %w", err)
@@ -421,11 +502,23 @@ func runner() error {
 		return validate(cfg)
 	}
 
+	if cfg.lint {
+		return lintTemplates(cfg)
+	}
+
+	if cfg.explain {
+		return explain(cfg, os.Stdout)
+	}
+
 	return run(cfg)
 }
 
 func main() {
 	err := runner()
+	if err == errChanges {
+		log.Infof("%s", err)
+		os.Exit(exitChanges)
+	}
 	if err != nil {
 		log.Errorf("fatal: %s", err)
 		os.Exit(1)
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nais/naisplater/pkg/templatetools"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Prefix of environment variables that set variables with --variables-from-env.
+const environmentPrefix = "NAISPLATER_VAR_"
+
+// override is a set of variables from the command line or the environment,
+// merged on top of the variables of every cluster.
+type override struct {
+	source string
+	path   string
+	vars   templatetools.Variables
+	rules  templatetools.Rules
+}
+
+// readOverrides returns the variables given with --values, the environment, --set-file and --set, in the order
+// they are merged. Files given with --values are read with load.
+func readOverrides(cfg *config, load loadFunc) ([]override, error) {
+	overrides := make([]override, 0)
+
+	for _, path := range cfg.values {
+		vars, rules, err := load(path)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, override{source: path, path: path, vars: vars, rules: rules})
+	}
+
+	if cfg.variablesFromEnv {
+		environment := os.Environ()
+		sort.Strings(environment)
+		for _, entry := range environment {
+			if !strings.HasPrefix(entry, environmentPrefix) {
+				continue
+			}
+			i := strings.Index(entry, "=")
+			name := entry[len(environmentPrefix):i]
+			vars, err := overrideVariables(strings.Replace(name, "__", ".", -1), entry[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("$%s: %w", entry[:i], err)
+			}
+			overrides = append(overrides, override{source: "$" + entry[:i], vars: vars})
+		}
+	}
+
+	for _, value := range cfg.setFiles {
+		key, path, err := keyValue("--set-file", value)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("--set-file %s: %w", key, err)
+		}
+		vars, err := overrideVariables(key, string(data))
+		if err != nil {
+			return nil, fmt.Errorf("--set-file %s: %w", key, err)
+		}
+		overrides = append(overrides, override{source: "--set-file " + key, vars: vars})
+	}
+
+	for _, value := range cfg.set {
+		key, value, err := keyValue("--set", value)
+		if err != nil {
+			return nil, err
+		}
+		vars, err := overrideVariables(key, value)
+		if err != nil {
+			return nil, fmt.Errorf("--set %s: %w", key, err)
+		}
+		overrides = append(overrides, override{source: "--set " + key, vars: vars})
+	}
+
+	return overrides, nil
+}
+
+// overrideVariables returns variables with a single string value at a path such as 'image.tag'.
+func overrideVariables(path, value string) (templatetools.Variables, error) {
+	keys := strings.Split(strings.TrimPrefix(path, "."), ".")
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, fmt.Errorf("invalid variable path '%s'", path)
+		}
+	}
+
+	vars := templatetools.Variables{keys[len(keys)-1]: value}
+	for i := len(keys) - 2; i >= 0; i-- {
+		vars = templatetools.Variables{keys[i]: vars}
+	}
+	return vars, nil
+}
+
+// mergeOverrides merges overrides on top of the variables of a cluster. Their values are escaped, as references
+// are only interpolated in variable files, but they can be referenced by variable files.
+func mergeOverrides(vars templatetools.Variables, overrides []override, policy *templatetools.Policy) error {
+	for _, o := range overrides {
+		overrideVars := templatetools.Copy(o.vars)
+		templatetools.EscapeVariables(overrideVars)
+		err := policy.With(o.rules).MergeMaps(vars, overrideVars)
+		if err != nil {
+			return fmt.Errorf("%s: %w", o.source, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"sync"
+)
+
+// parallel calls fn for every index in [0, n) using at most jobs goroutines,
+// and returns when all calls have completed.
+func parallel(jobs, n int, fn func(i int)) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	indexes := make(chan int)
+	wg := &sync.WaitGroup{}
+
+	for worker := 0; worker < jobs && worker < n; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+
+	wg.Wait()
+}
+
+// orderedOutput buffers the log output of concurrent tasks, and writes it in task order
+// as soon as all preceding tasks have completed. This keeps the log deterministic
+// regardless of the order in which the tasks finish.
+type orderedOutput struct {
+	lock    sync.Mutex
+	out     io.Writer
+	buffers []*bytes.Buffer
+	done    []bool
+	next    int
+}
+
+func newOrderedOutput(out io.Writer, tasks int) *orderedOutput {
+	o := &orderedOutput{
+		out:     out,
+		buffers: make([]*bytes.Buffer, tasks),
+		done:    make([]bool, tasks),
+	}
+	for i := range o.buffers {
+		o.buffers[i] = &bytes.Buffer{}
+	}
+	return o
+}
+
+// writer returns the buffer of the given task.
+func (o *orderedOutput) writer(task int) io.Writer {
+	return o.buffers[task]
+}
+
+// logger returns a logger with the same settings as the standard logger,
+// which writes to the buffer of the given task.
+func (o *orderedOutput) logger(task int) *log.Logger {
+	std := log.StandardLogger()
+	logger := log.New()
+	logger.SetOutput(o.buffers[task])
+	logger.SetFormatter(std.Formatter)
+	logger.SetLevel(std.GetLevel())
+	return logger
+}
+
+// finish marks a task as completed, and flushes the output of all completed tasks
+// that are not waiting for an earlier task.
+func (o *orderedOutput) finish(task int) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.done[task] = true
+	for o.next < len(o.done) && o.done[o.next] {
+		_, _ = o.out.Write(o.buffers[o.next].Bytes())
+		o.buffers[o.next] = nil
+		o.next++
+	}
+}
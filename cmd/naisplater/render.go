@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/nais/naisplater/pkg/cryptutil"
+	"github.com/nais/naisplater/pkg/inventory"
+	"github.com/nais/naisplater/pkg/jsonschema"
+	"github.com/nais/naisplater/pkg/kubeschema"
+	"github.com/nais/naisplater/pkg/metadata"
+	"github.com/nais/naisplater/pkg/report"
+	"github.com/nais/naisplater/pkg/templatefuncs"
+	"github.com/nais/naisplater/pkg/templatetools"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// parseTemplate parses a template file together with all partials,
+// so that templates defined in the partials can be used by the template.
+// The template is named by its path relative to the template directory, such as 'app/deployment.yaml',
+// as templates in different directories can have the same file name. Partials are named by their file name.
+func parseTemplate(name, path string, partials []string) (*template.Template, error) {
+	tpl := template.New(name)
+
+	// Register helper functions
+	tpl = tpl.Funcs(templatefuncs.FuncMap())
+	tpl = tpl.Funcs(template.FuncMap{"include": templatefuncs.Include(tpl)})
+
+	if len(partials) > 0 {
+		_, err := tpl.ParseFiles(partials...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tpl.Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	// Nice API. Fail on undefined template variables.
+	tpl.Option("missingkey=error")
+
+	return tpl, nil
+}
+
+// render executes a template and post-processes the output according to its format.
+// Labels and annotations are injected into every YAML or JSON resource unless meta is nil,
+// JSON output is checked for syntax errors, and raw output is returned as is.
+// Resources without metadata, and values that changed when labels were added, are returned as warnings.
+// Errors in the rendered output are returned as a *renderError.
+func render(tpl *template.Template, vars templatetools.Variables, meta *metadata.Metadata, format string) ([]byte, []string, error) {
+	output := newSourceMap(tpl)
+	err := tpl.Execute(output, vars)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if format == formatRaw {
+		return output.Bytes(), nil, nil
+	}
+
+	if format == formatJSON {
+		err = metadata.ValidateJSON(output.Bytes())
+		if err != nil {
+			return nil, nil, newRenderError(fmt.Errorf("invalid JSON: %w", err), output)
+		}
+	}
+
+	if meta == nil || meta.Empty() {
+		return output.Bytes(), nil, nil
+	}
+
+	inject := meta.Inject
+	if format == formatJSON {
+		inject = meta.InjectJSON
+	}
+
+	out, warnings, err := inject(output.Bytes())
+	if err != nil {
+		return nil, nil, newRenderError(err, output)
+	}
+
+	return out, warnings, nil
+}
+
+// renderer holds state that is shared between all clusters rendered in one invocation,
+// so that templates are parsed and global variables are decrypted only once.
+type renderer struct {
+	cfg          *config
+	layout       *templateLayout
+	metadata     *metadata.Config
+	volatile     *regexp.Regexp
+	formats      *formats
+	schemas      *kubeschema.Validator
+	report       *report.Report
+	changes      int64
+	lock         sync.Mutex
+	templates    map[string]*parsedTemplate
+	slots        chan struct{}
+	layers       templatetools.Layers
+	overrides    []override
+	variables    *jsonschema.Schema
+	policy       *templatetools.Policy
+	globals      templatetools.Variables
+	globalRules  templatetools.Rules
+	globalErrors int
+}
+
+// parsedTemplate is parsed once, and then executed concurrently for every cluster.
+type parsedTemplate struct {
+	once sync.Once
+	tpl  *template.Template
+	err  error
+}
+
+// renderResult holds the outcome of rendering a single template. Problems found in the rendered output,
+// such as schema violations, are added to errors, while err is set if the template could not be rendered.
+type renderResult struct {
+	path     string
+	filename string
+	output   string
+	format   string
+	data     []byte
+	warnings []string
+	errors   []string
+	duration time.Duration
+	err      error
+}
+
+func newRenderer(cfg *config) (*renderer, error) {
+	var err error
+
+	r := &renderer{
+		cfg:       cfg,
+		report:    report.New(),
+		templates: make(map[string]*parsedTemplate),
+		slots:     make(chan struct{}, cfg.jobs),
+	}
+
+	r.layout, err = newTemplateLayout(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r.metadata, err = metadataConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.volatile = volatilePattern(r.metadata)
+
+	r.formats, err = readFormats(cfg.templates)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.validate && cfg.validateSchemas {
+		if len(cfg.schemas) > 0 {
+			log.Debugf("Using additional schemas from %s", cfg.schemas)
+		}
+		r.schemas, err = kubeschema.New(cfg.schemas)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.layers, err = readLayers(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	r.policy, err = readPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	globals, err := layerFiles(cfg, templatetools.GlobalLayer)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Using global variables from %s", strings.Join(globals, ", "))
+
+	r.globals, r.globalRules, r.globalErrors, err = loadLayer(cfg, globals, log.StandardLogger())
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.variablesSchema) > 0 {
+		log.Debugf("Validating variables against %s", cfg.variablesSchema)
+		r.variables, err = readVariablesSchema(cfg.variablesSchema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.overrides, err = readOverrides(cfg, func(path string) (templatetools.Variables, templatetools.Rules, error) {
+		vars, rules, errors, err := loadVariables(cfg, path, false, log.StandardLogger())
+		r.globalErrors += errors
+		return vars, rules, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// loadVariables reads and decrypts a variable file, and returns it with the merge rules set by tags in the file.
+// If escape is set, decrypted values are escaped, so that references are only interpolated in plaintext values.
+// Overrides are escaped as a whole when merged instead. A missing decryption key is reported as a non-fatal error.
+func loadVariables(cfg *config, path string, escape bool, logger log.FieldLogger) (templatetools.Variables, templatetools.Rules, int, error) {
+	vars, rules, err := templatetools.ReadVariables(path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	decrypt := cryptutil.DecryptWithPassword
+	if escape {
+		decrypt = decryptLiteral
+	}
+
+	logger.Debugf("Decrypting variables in %s", path)
+	err = templatetools.CryptTransform(vars, cfg.decryptionKey, decrypt, true)
+	if err != nil {
+		if len(cfg.decryptionKey) == 0 {
+			logger.Errorf("decrypt variable: %s", err)
+			logger.Warnf("Decryption key is missing; skipping all variable decryption")
+			return vars, rules, 1, nil
+		}
+		return nil, nil, 0, err
+	}
+
+	return vars, rules, 0, nil
+}
+
+// decryptLiteral decrypts a value, and escapes it so that it is not interpolated.
+func decryptLiteral(ciphertext, password string) (string, error) {
+	plaintext, err := cryptutil.DecryptWithPassword(ciphertext, password)
+	if err != nil {
+		return "", err
+	}
+	return templatetools.EscapeReferences(plaintext), nil
+}
+
+// template returns a parsed template. Templates are cached by name, path and partials,
+// as cluster-specific partials result in a different template set.
+func (r *renderer) template(name, path string, partials []string) (*template.Template, error) {
+	key := strings.Join(append(append([]string{}, partials...), name, path), "\x00")
+
+	r.lock.Lock()
+	entry, ok := r.templates[key]
+	if !ok {
+		entry = &parsedTemplate{}
+		r.templates[key] = entry
+	}
+	r.lock.Unlock()
+
+	entry.once.Do(func() {
+		entry.tpl, entry.err = parseTemplate(name, path, partials)
+	})
+
+	return entry.tpl, entry.err
+}
+
+// loadLayer reads, decrypts and merges the variable files of a layer.
+func loadLayer(cfg *config, paths []string, logger log.FieldLogger) (templatetools.Variables, templatetools.Rules, int, error) {
+	errors := 0
+	vars, rules, err := mergeLayer(paths, func(path string) (templatetools.Variables, templatetools.Rules, error) {
+		vars, rules, fileErrors, err := loadVariables(cfg, path, true, logger)
+		errors += fileErrors
+		return vars, rules, err
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	return vars, rules, errors, nil
+}
+
+func (r *renderer) clusterVariables(cluster string, logger log.FieldLogger) (templatetools.Variables, int, error) {
+	groups, err := variableFiles(r.cfg, r.layers, cluster)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the global variables are loaded once, and merged first for every cluster
+	vars := templatetools.Variables{}
+	errors := r.globalErrors
+	err = r.policy.With(r.globalRules).MergeMaps(vars, templatetools.Copy(r.globals))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", strings.Join(groups[0], ", "), err)
+	}
+
+	for _, paths := range groups[1:] {
+		logger.Debugf("Using cluster-override variables from %s", strings.Join(paths, ", "))
+
+		layerVars, rules, layerErrors, err := loadLayer(r.cfg, paths, logger)
+		if err != nil {
+			return nil, 0, err
+		}
+		errors += layerErrors
+
+		err = r.policy.With(rules).MergeMaps(vars, layerVars)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", strings.Join(paths, ", "), err)
+		}
+	}
+
+	err = mergeOverrides(vars, r.overrides, r.policy)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = templatetools.Interpolate(vars)
+	if err != nil {
+		return nil, 0, fmt.Errorf("interpolate variables: %w", err)
+	}
+
+	if r.variables != nil {
+		err = validateVariables(r.variables, r.cfg.variablesSchema, vars, logger)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return vars, errors, nil
+}
+
+// metadataConfig returns the labels and annotations to inject, or nil if injection is disabled.
+func metadataConfig(cfg *config) (*metadata.Config, error) {
+	var err error
+
+	if !cfg.addLabels {
+		return nil, nil
+	}
+
+	meta := metadata.DefaultConfig()
+	if len(cfg.metadataConfig) > 0 {
+		log.Debugf("Using labels and annotations from %s", cfg.metadataConfig)
+		meta, err = metadata.ReadConfig(cfg.metadataConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	labels, err := keyValues("--label", cfg.labels)
+	if err != nil {
+		return nil, err
+	}
+	annotations, err := keyValues("--annotation", cfg.annotations)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	merge(meta.Labels, labels)
+	merge(meta.Annotations, annotations)
+
+	return meta, nil
+}
+
+// clusterMetadata resolves label and annotation templates with the cluster variables.
+func (r *renderer) clusterMetadata(vars templatetools.Variables) (*metadata.Metadata, error) {
+	if r.metadata == nil {
+		return nil, nil
+	}
+
+	funcs := templatefuncs.FuncMap()
+	funcs["touchedAt"] = func() string {
+		return r.cfg.touchedAt
+	}
+
+	return r.metadata.Resolve(vars, funcs)
+}
+
+// renderTemplate parses and renders a single template, waiting for a free slot
+// so that no more than --jobs templates are rendered at the same time.
+func (r *renderer) renderTemplate(name, path string, partials []string, vars templatetools.Variables, meta *metadata.Metadata, format string) ([]byte, []string, error) {
+	r.slots <- struct{}{}
+	defer func() {
+		<-r.slots
+	}()
+
+	tpl, err := r.template(name, path, partials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return render(tpl, vars, meta, format)
+}
+
+// renderCluster renders all templates for one cluster into the output directory.
+// In diff and stream mode, the differences or rendered templates are written to stdout instead.
+// The results are added to the report.
+func (r *renderer) renderCluster(cluster, outputDirectory string, logger log.FieldLogger, stdout io.Writer) (err error) {
+	cfg := r.cfg
+	start := time.Now()
+	var results []renderResult
+
+	defer func() {
+		r.addReport(cluster, results, time.Since(start), err)
+	}()
+
+	vars, errors, err := r.clusterVariables(cluster, logger)
+	if err != nil {
+		return err
+	}
+
+	meta, err := r.clusterMetadata(vars)
+	if err != nil {
+		return err
+	}
+
+	templates, err := r.layout.clusterTemplates(cluster, logger)
+	if err != nil {
+		return err
+	}
+
+	partials, filenames, err := splitPartials(templates)
+	if err != nil {
+		return err
+	}
+
+	if len(partials) > 0 {
+		logger.Debugf("Using partials %s", strings.Join(partials, ", "))
+	}
+
+	if cfg.writeFiles() {
+		logger.Debugf("Using output directory %s", outputDirectory)
+		err = os.MkdirAll(outputDirectory, 0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	results = make([]renderResult, len(filenames))
+	parallel(cfg.jobs, len(filenames), func(i int) {
+		templateStart := time.Now()
+		result := &results[i]
+		result.filename = filenames[i]
+		result.path = templates[result.filename]
+		result.output = filepath.Join(outputDirectory, result.filename)
+		if cfg.stream() {
+			result.output = "stdout"
+		}
+		result.format = r.formats.format(result.filename)
+		result.data, result.warnings, result.err = r.renderTemplate(result.filename, result.path, partials, vars, meta, result.format)
+		if result.err == nil && cfg.writeFiles() {
+			result.err = writeFile(result.output, result.data)
+		}
+		if result.err == nil && result.format == formatRaw && cfg.stream() {
+			result.warnings = append(result.warnings, "not YAML or JSON; left out of the output stream")
+		}
+		if result.err == nil && result.format != formatRaw && r.schemas != nil {
+			validateSchemas(r.schemas, result)
+		}
+		result.duration = time.Since(templateStart)
+	})
+
+	checkDuplicates(results, logger)
+
+	for _, result := range results {
+		if result.err != nil {
+			errors++
+			logRenderError(logger, result.path, result.err)
+		} else {
+			logger.Debugf("Rendered %s to %s", result.path, result.output)
+		}
+		for _, warning := range result.warnings {
+			logger.Warnf("%s: %s", result.path, warning)
+		}
+		for _, message := range result.errors {
+			errors++
+			logger.Errorf("%s: %s", result.path, message)
+		}
+	}
+
+	if cfg.writeFiles() {
+		err = r.updateInventory(outputDirectory, results, errors == 0, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.stream() && errors == 0 {
+		err = writeStream(stdout, cluster, results, cfg.multiCluster())
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.diff && errors == 0 {
+		changes, err := diffOutput(outputDirectory, results, r.volatile, stdout)
+		if err != nil {
+			return err
+		}
+		logger.Infof("%d files changed in %s", changes, outputDirectory)
+		atomic.AddInt64(&r.changes, int64(changes))
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("encountered %d errors; see log", errors)
+	}
+
+	return nil
+}
+
+// renderClusters renders each cluster into its own subdirectory of the output directory,
+// and reports all failing clusters at the end.
+func (r *renderer) renderClusters(clusters []string) error {
+	action := "Rendering"
+	if r.cfg.validate {
+		action = "Validation"
+	}
+
+	output := newOrderedOutput(log.StandardLogger().Out, len(clusters))
+	stdout := newOrderedOutput(os.Stdout, len(clusters))
+	errs := make([]error, len(clusters))
+
+	parallel(r.cfg.jobs, len(clusters), func(i int) {
+		logger := output.logger(i)
+		logger.Infof("Running %s for cluster '%s'", strings.ToLower(action), clusters[i])
+
+		errs[i] = r.renderCluster(clusters[i], filepath.Join(r.cfg.output, clusters[i]), logger, stdout.writer(i))
+		if errs[i] != nil {
+			logger.Errorf("%s failed for cluster '%s': %s", action, clusters[i], errs[i])
+		}
+
+		output.finish(i)
+		stdout.finish(i)
+	})
+
+	failed := make([]string, 0)
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, clusters[i])
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d clusters failed %s: %s", len(failed), len(clusters), strings.ToLower(action), strings.Join(failed, ", "))
+	}
+
+	log.Infof("All clusters rendered successfully")
+
+	return nil
+}
+
+// updateInventory records the generated files in the output directory, and removes or lists
+// previously generated files that are no longer generated. Nothing is removed if any template failed,
+// and stale files are kept in the inventory until they are removed.
+func (r *renderer) updateInventory(outputDirectory string, results []renderResult, complete bool, logger log.FieldLogger) error {
+	previous, err := inventory.Read(outputDirectory)
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.err == nil {
+			files = append(files, result.filename)
+		}
+	}
+	current := inventory.New(files)
+	stale := previous.Stale(current)
+
+	switch {
+	case len(stale) == 0:
+	case !complete:
+		logger.Warnf("Not pruning stale files in %s because of render errors", outputDirectory)
+	case r.cfg.prune:
+		for _, file := range stale {
+			logger.Infof("Removing stale file %s", filepath.Join(outputDirectory, file))
+		}
+		err = inventory.Remove(outputDirectory, stale)
+		if err != nil {
+			return err
+		}
+		stale = nil
+	case r.cfg.pruneDryRun:
+		for _, file := range stale {
+			logger.Infof("Stale file %s would be removed by --prune", filepath.Join(outputDirectory, file))
+		}
+	default:
+		logger.Warnf("%d previously generated files in %s are no longer generated; run with --prune to remove them", len(stale), outputDirectory)
+	}
+
+	current.Merge(inventory.New(stale))
+
+	return current.Write(outputDirectory)
+}
+
+// writeFile writes a rendered template, creating parent directories as needed.
+func writeFile(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// writeStream writes rendered templates to stdout as a single multi-document YAML stream,
+// with a comment naming the source template of each document. Raw templates are left out.
+func writeStream(out io.Writer, cluster string, results []renderResult, multiCluster bool) error {
+	buf := &bytes.Buffer{}
+
+	for _, result := range results {
+		if result.format == formatRaw {
+			continue
+		}
+		data := bytes.TrimPrefix(result.data, []byte("---\n"))
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		buf.WriteString("---\n")
+		if multiCluster {
+			fmt.Fprintf(buf, "# Cluster: %s\n", cluster)
+		}
+		fmt.Fprintf(buf, "# Source: %s\n", result.path)
+		buf.Write(data)
+		if !bytes.HasSuffix(data, []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	}
+
+	_, err := out.Write(buf.Bytes())
+	return err
+}
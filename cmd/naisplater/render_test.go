@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nais/naisplater/pkg/cryptutil"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+	return dir
+}
+
+func TestParseTemplate(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"_helpers.tpl": `{{ define "name" }}{{ .clusterName }}{{ end }}`,
+		"a/app.yaml":   "a: {{ template \"name\" . }}\n",
+		"b/app.yaml":   "b: {{ include \"name\" . }}\n{{ .missing }}\n",
+	})
+	partials := []string{filepath.Join(dir, "_helpers.tpl")}
+
+	tpl, err := parseTemplate("a/app.yaml", filepath.Join(dir, "a/app.yaml"), partials)
+	assert.NoError(t, err)
+	out := &bytes.Buffer{}
+	assert.NoError(t, tpl.Execute(out, map[string]string{"clusterName": "prod-gcp"}))
+	assert.Equal(t, "a: prod-gcp\n", out.String())
+
+	// errors name the template by its relative path, as both templates are named app.yaml
+	tpl, err = parseTemplate("b/app.yaml", filepath.Join(dir, "b/app.yaml"), partials)
+	assert.NoError(t, err)
+	err = tpl.Execute(&bytes.Buffer{}, map[string]string{"clusterName": "prod-gcp"})
+	assert.EqualError(t, err, `template: b/app.yaml:2:3: executing "b/app.yaml" at <.missing>: map has no entry for key "missing"`)
+}
+
+func TestClusterVariablesInterpolatesOnlyPlaintext(t *testing.T) {
+	secret, err := cryptutil.EncryptWithPassword("p${HOME}w", "key")
+	assert.NoError(t, err)
+	variables := writeTemplates(t, map[string]string{
+		"global.yaml": "domain: example.com\nhost: app.${domain}\npassword.enc: " + secret + "\ndsn: user:${password}@${host}\n",
+		"dev.yaml":    "cluster: dev\n",
+	})
+	values := writeTemplates(t, map[string]string{"values.yaml": "envsubst: $${HOST}-${HOST}\n"})
+
+	cfg := &config{
+		templates:     writeTemplates(t, map[string]string{"app.yaml": ""}),
+		variables:     variables,
+		decryptionKey: "key",
+		jobs:          1,
+		set:           []string{"domain=${HOME}.example.com", "shell=echo ${HOME}"},
+		values:        []string{filepath.Join(values, "values.yaml")},
+	}
+	r, err := newRenderer(cfg)
+	assert.NoError(t, err)
+
+	logger, _ := logtest.NewNullLogger()
+	vars, errors, err := r.clusterVariables("dev", logger)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, errors)
+	assert.Equal(t, "p${HOME}w", vars["password"])
+	assert.Equal(t, "${HOME}.example.com", vars["domain"])
+	assert.Equal(t, "app.${HOME}.example.com", vars["host"])
+	assert.Equal(t, "user:p${HOME}w@app.${HOME}.example.com", vars["dsn"])
+	assert.Equal(t, "echo ${HOME}", vars["shell"])
+	assert.Equal(t, "$${HOST}-${HOST}", vars["envsubst"])
+}
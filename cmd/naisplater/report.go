@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nais/naisplater/pkg/report"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"time"
+)
+
+// addReport adds the results of a cluster to the report.
+func (r *renderer) addReport(cluster string, results []renderResult, duration time.Duration, err error) {
+	templates := make([]report.Template, 0, len(results))
+	for _, result := range results {
+		errors := make([]string, 0, len(result.errors)+1)
+		if result.err != nil {
+			errors = append(errors, result.err.Error())
+		}
+		errors = append(errors, result.errors...)
+		templates = append(templates, report.NewTemplate(result.path, errors, result.warnings, result.duration))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.report.Add(report.NewCluster(cluster, templates, duration, err))
+}
+
+// writeReport writes the report to --report-file, or to STDOUT, if --report-format is given.
+func (r *renderer) writeReport(duration time.Duration) error {
+	if len(r.cfg.reportFormat) == 0 {
+		return nil
+	}
+
+	r.report.Duration = duration.Seconds()
+
+	if len(r.cfg.reportFile) == 0 || r.cfg.reportFile == streamOutput {
+		return r.report.Write(os.Stdout, r.cfg.reportFormat)
+	}
+
+	file, err := os.Create(r.cfg.reportFile)
+	if err != nil {
+		return err
+	}
+
+	err = r.report.Write(file, r.cfg.reportFormat)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	log.Debugf("Wrote %s report to %s", r.cfg.reportFormat, r.cfg.reportFile)
+
+	return file.Close()
+}
+
+// finishReport writes the report, and returns the first of the rendering and reporting errors.
+func finishReport(r *renderer, err error, duration time.Duration) error {
+	reportErr := r.writeReport(duration)
+	if reportErr == nil {
+		return err
+	}
+	if err != nil {
+		log.Errorf("write report: %s", reportErr)
+		return err
+	}
+	return fmt.Errorf("write report: %w", reportErr)
+}
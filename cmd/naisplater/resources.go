@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io"
+	"strings"
+)
+
+// decodeDocuments decodes all documents in a rendered template. Empty documents are decoded as nil,
+// so that the index of each document matches its position in the file.
+func decodeDocuments(data []byte) ([]interface{}, error) {
+	documents := make([]interface{}, 0)
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return documents, nil
+		} else if err != nil {
+			return documents, fmt.Errorf("document %d: %w", len(documents)+1, err)
+		}
+		documents = append(documents, document)
+	}
+}
+
+// resources returns the resources in a document, which are the items of List documents.
+func resources(document interface{}) []map[interface{}]interface{} {
+	object, ok := document.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+
+	kind, _ := object["kind"].(string)
+	items, ok := object["items"].([]interface{})
+	if !ok || !strings.HasSuffix(kind, "List") {
+		return []map[interface{}]interface{}{object}
+	}
+
+	result := make([]map[interface{}]interface{}, 0, len(items))
+	for _, item := range items {
+		if resource, ok := item.(map[interface{}]interface{}); ok {
+			result = append(result, resource)
+		}
+	}
+	return result
+}
+
+// resourceName returns a short description of a resource, such as 'Deployment/app'.
+func resourceName(resource interface{}) string {
+	object, _ := resource.(map[interface{}]interface{})
+	metadata, _ := object["metadata"].(map[interface{}]interface{})
+	if metadata["name"] == nil {
+		return fmt.Sprint(object["kind"])
+	}
+	return fmt.Sprintf("%v/%v", object["kind"], metadata["name"])
+}
+
+// resourceID identifies a resource within a cluster.
+type resourceID struct {
+	apiVersion string
+	kind       string
+	namespace  string
+	name       string
+}
+
+func newResourceID(resource map[interface{}]interface{}) (resourceID, bool) {
+	metadata, _ := resource["metadata"].(map[interface{}]interface{})
+	id := resourceID{
+		apiVersion: fmt.Sprint(resource["apiVersion"]),
+		kind:       fmt.Sprint(resource["kind"]),
+	}
+	if metadata["namespace"] != nil {
+		id.namespace = fmt.Sprint(metadata["namespace"])
+	}
+	if resource["kind"] == nil || metadata["name"] == nil {
+		return id, false
+	}
+	id.name = fmt.Sprint(metadata["name"])
+	return id, true
+}
+
+func (id resourceID) String() string {
+	if len(id.namespace) == 0 {
+		return fmt.Sprintf("%s %s (%s)", id.kind, id.name, id.apiVersion)
+	}
+	return fmt.Sprintf("%s %s/%s (%s)", id.kind, id.namespace, id.name, id.apiVersion)
+}
+
+// checkDuplicates reports resources that are rendered more than once for a cluster,
+// as the last one applied would silently win. Duplicates are added to the errors of the later result.
+func checkDuplicates(results []renderResult, logger log.FieldLogger) {
+	type source struct {
+		path     string
+		document int
+	}
+
+	seen := make(map[resourceID]source)
+
+	for i := range results {
+		result := &results[i]
+		if result.err != nil || result.format == formatRaw {
+			continue
+		}
+		documents, err := decodeDocuments(result.data)
+		if err != nil {
+			logger.Debugf("%s: not checked for duplicate resources: %s", result.path, err)
+		}
+		for j, document := range documents {
+			current := source{path: result.path, document: j + 1}
+			for _, resource := range resources(document) {
+				id, ok := newResourceID(resource)
+				if !ok {
+					continue
+				}
+				if first, found := seen[id]; found {
+					result.errors = append(result.errors, fmt.Sprintf("document %d: duplicate resource %s, also rendered by %s (document %d)", current.document, id, first.path, first.document))
+					continue
+				}
+				seen[id] = current
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nais/naisplater/pkg/jsonschema"
+	"github.com/nais/naisplater/pkg/kubeschema"
+	"github.com/nais/naisplater/pkg/templatetools"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+)
+
+// validateSchemas validates every document in a rendered template against its Kubernetes schema,
+// and adds all violations to the errors of the result. Documents without a schema are skipped with a warning.
+func validateSchemas(validator *kubeschema.Validator, result *renderResult) {
+	documents, err := decodeDocuments(result.data)
+	if err != nil {
+		result.errors = append(result.errors, err.Error())
+		return
+	}
+
+	for i, resource := range documents {
+		document := i + 1
+		if resource == nil {
+			continue
+		}
+
+		violations, err := validator.Validate(resource)
+		if errors.Is(err, kubeschema.ErrNoSchema) {
+			result.warnings = append(result.warnings, fmt.Sprintf("document %d: %s; not validated", document, err))
+			continue
+		} else if err != nil {
+			result.errors = append(result.errors, fmt.Sprintf("document %d: %s", document, err))
+			continue
+		}
+
+		for _, violation := range violations {
+			result.errors = append(result.errors, fmt.Sprintf("document %d (%s): %s", document, resourceName(resource), violation))
+		}
+	}
+}
+
+// readVariablesSchema reads a JSON schema for the variables of every cluster, written as JSON or YAML.
+func readVariablesSchema(path string) (*jsonschema.Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		var value interface{}
+		err = yaml.Unmarshal(data, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		data, err = json.Marshal(kubeschema.JSONValue(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	schema, err := jsonschema.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return schema, nil
+}
+
+// validateVariables validates the merged variables of a cluster against the variables schema,
+// and logs every violation as an error.
+func validateVariables(schema *jsonschema.Schema, path string, vars templatetools.Variables, logger log.FieldLogger) error {
+	violations := schema.Validate(kubeschema.JSONValue(vars))
+	for _, violation := range violations {
+		logger.Errorf("%s: %s", path, violation)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("variables do not match %s: encountered %d errors; see log", path, len(violations))
+	}
+	return nil
+}
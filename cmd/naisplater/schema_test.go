@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nais/naisplater/pkg/templatetools"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+const variablesSchemaJSON = `{
+  "type": "object",
+  "required": ["clusterName"],
+  "properties": {
+    "clusterName": {"type": "string"},
+    "replicas": {"type": "integer", "minimum": 1},
+    "env": {"enum": ["dev", "prod"]}
+  }
+}`
+
+const variablesSchemaYAML = `type: object
+required: [clusterName]
+properties:
+  clusterName:
+    type: string
+  replicas:
+    type: integer
+    minimum: 1
+  env:
+    enum: [dev, prod]
+`
+
+func TestReadVariablesSchema(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"schema.json", variablesSchemaJSON, ""},
+		{"schema.yaml", variablesSchemaYAML, ""},
+		{"schema.yml", variablesSchemaYAML, ""},
+		{"schema-yaml.json", variablesSchemaYAML, "schema-yaml.json: invalid character 'y' in literal true (expecting 'r')"},
+		{"invalid.yaml", "type: [object\n", "invalid.yaml: yaml: line 1: did not find expected ',' or ']'"},
+		{"reference.json", `{"$ref": "#/definitions/missing"}`, "reference.json: $ref '#/definitions/missing': pointer '/definitions/missing' not found"},
+		{"pattern.yaml", "properties:\n  env:\n    pattern: '[a-'\n", "pattern.yaml: #/properties/env: invalid pattern '[a-': error parsing regexp: missing closing ]: `[a-`"},
+		{"missing.json", "", "open missing.json: no such file or directory"},
+	} {
+		path := filepath.Join(dir, test.name)
+		if test.name != "missing.json" {
+			assert.NoError(t, os.WriteFile(path, []byte(test.data), 0644))
+		}
+
+		schema, err := readVariablesSchema(path)
+		if len(test.err) == 0 {
+			assert.NoError(t, err, test.name)
+			assert.NotNil(t, schema, test.name)
+			continue
+		}
+		if assert.Error(t, err, test.name) {
+			assert.Equal(t, test.err, strings.Replace(err.Error(), dir+string(filepath.Separator), "", -1), test.name)
+		}
+	}
+}
+
+func TestValidateVariables(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"schema.json", "schema.yaml"} {
+		data := variablesSchemaJSON
+		if name == "schema.yaml" {
+			data = variablesSchemaYAML
+		}
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+		schema, err := readVariablesSchema(path)
+		assert.NoError(t, err)
+
+		for _, test := range []struct {
+			vars       templatetools.Variables
+			violations []string
+		}{
+			{
+				vars: templatetools.Variables{"clusterName": "prod-gcp", "replicas": 3, "env": "prod", "extra": templatetools.Variables{1: true}},
+			},
+			{
+				vars: templatetools.Variables{"replicas": 0, "env": "staging"},
+				violations: []string{
+					path + ": clusterName: required field is missing",
+					path + ": env: must be one of: dev, prod",
+					path + ": replicas: must be greater than or equal to 1",
+				},
+			},
+		} {
+			logger, hook := logtest.NewNullLogger()
+			err := validateVariables(schema, path, test.vars, logger)
+
+			messages := make([]string, 0)
+			for _, entry := range hook.AllEntries() {
+				messages = append(messages, entry.Message)
+			}
+			if len(test.violations) == 0 {
+				assert.NoError(t, err, name)
+				assert.Empty(t, messages, name)
+				continue
+			}
+			assert.EqualError(t, err, "variables do not match "+path+": encountered 3 errors; see log", name)
+			assert.ElementsMatch(t, test.violations, messages, name)
+		}
+	}
+}
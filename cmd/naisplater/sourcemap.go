@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// sourceMap collects the output of a template, and records which template text produced each part of it.
+// Text in the template is written as is, so output from a text node is mapped to its exact template line.
+// Output from actions, such as variables and function calls, is mapped to the line where the action starts.
+type sourceMap struct {
+	bytes.Buffer
+	nodes    map[*byte]templateText
+	segments []segment
+}
+
+// templateText is a text node in a template file.
+type templateText struct {
+	tree *parse.Tree
+	node *parse.TextNode
+}
+
+// segment is a part of the output, starting at offset, written by a text node or by an action at pos.
+type segment struct {
+	offset int
+	tree   *parse.Tree
+	pos    parse.Pos
+	text   bool
+}
+
+func newSourceMap(tpl *template.Template) *sourceMap {
+	m := &sourceMap{
+		nodes: make(map[*byte]templateText),
+	}
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil && t.Tree.Root != nil {
+			m.addNodes(t.Tree, t.Tree.Root)
+		}
+	}
+	return m
+}
+
+func (m *sourceMap) addNodes(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.TextNode:
+		if len(n.Text) > 0 {
+			m.nodes[&n.Text[0]] = templateText{tree: tree, node: n}
+		}
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			m.addNodes(tree, child)
+		}
+	case *parse.IfNode:
+		m.addNodes(tree, n.List)
+		m.addNodes(tree, n.ElseList)
+	case *parse.RangeNode:
+		m.addNodes(tree, n.List)
+		m.addNodes(tree, n.ElseList)
+	case *parse.WithNode:
+		m.addNodes(tree, n.List)
+		m.addNodes(tree, n.ElseList)
+	}
+}
+
+func (m *sourceMap) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		s := segment{offset: m.Len()}
+		if text, ok := m.nodes[&p[0]]; ok && len(text.node.Text) == len(p) {
+			s.tree, s.pos, s.text = text.tree, text.node.Pos, true
+		} else if len(m.segments) > 0 {
+			// The action starts where the preceding text ends.
+			last := m.segments[len(m.segments)-1]
+			s.tree, s.pos = last.tree, last.pos
+			if last.text {
+				s.pos += parse.Pos(s.offset - last.offset)
+			}
+		}
+		m.segments = append(m.segments, s)
+	}
+	return m.Buffer.Write(p)
+}
+
+// location returns the template file and line, such as 'app.yaml:12', that produced the output at offset,
+// or an empty string if it is not known.
+func (m *sourceMap) location(offset int) string {
+	i := sort.Search(len(m.segments), func(i int) bool {
+		return m.segments[i].offset > offset
+	}) - 1
+	if i < 0 || m.segments[i].tree == nil {
+		return ""
+	}
+
+	s := m.segments[i]
+	pos := s.pos
+	if s.text {
+		pos += parse.Pos(offset - s.offset)
+	}
+
+	location, _ := s.tree.ErrorContext(&parse.TextNode{NodeType: parse.NodeText, Pos: pos})
+	// Strip the column, which is not meaningful for rendered output.
+	return location[:strings.LastIndex(location, ":")]
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Reserved directory in the template directory, holding cluster-specific templates in clusters/<cluster>/.
+const clusterTemplatesDirectory = "clusters"
+
+// templateLayout describes where to find templates, and where to find cluster-specific overrides.
+//
+// If the template directory contains a `clusters` directory, cluster-specific templates are
+// read from `clusters/<cluster>/`, and all other subdirectories contain regular templates.
+// Otherwise, top-level subdirectories named after a cluster are used for cluster-specific templates,
+// and subdirectories named after any variable file or directory, including layers, are skipped.
+type templateLayout struct {
+	directory    string
+	clustersTree bool
+	clusterDirs  map[string]bool
+}
+
+func newTemplateLayout(cfg *config) (*templateLayout, error) {
+	layout := &templateLayout{
+		directory:   cfg.templates,
+		clusterDirs: make(map[string]bool),
+	}
+
+	info, err := os.Stat(filepath.Join(cfg.templates, clusterTemplatesDirectory))
+	if err == nil && info.IsDir() {
+		layout.clustersTree = true
+		return layout, nil
+	}
+
+	// directories named after layers are skipped as well, as they are not shared templates either
+	names, err := variableNames(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		layout.clusterDirs[name] = true
+	}
+
+	return layout, nil
+}
+
+func (layout *templateLayout) overrideDirectory(cluster string) string {
+	if layout.clustersTree {
+		return filepath.Join(layout.directory, clusterTemplatesDirectory, cluster)
+	}
+	return filepath.Join(layout.directory, cluster)
+}
+
+// skipDirectory returns true for top-level directories that contain cluster-specific templates.
+func (layout *templateLayout) skipDirectory(relativePath string) bool {
+	if layout.clustersTree {
+		return relativePath == clusterTemplatesDirectory
+	}
+	return layout.clusterDirs[relativePath]
+}
+
+// clusterTemplates returns all templates for a cluster, keyed by their path relative to
+// the template directory. Cluster-specific templates override templates with the same path.
+func (layout *templateLayout) clusterTemplates(cluster string, logger log.FieldLogger) (map[string]string, error) {
+	logger.Debugf("Using templates from %s", layout.directory)
+
+	templates, err := directoryTemplates(layout.directory, layout.skipDirectory)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterTemplates := layout.overrideDirectory(cluster)
+	overrides, err := directoryTemplates(clusterTemplates, nil)
+	if err != nil {
+		if os.IsNotExist(err) {
+			logger.Debugf("No cluster-specific template directory for '%s'", cluster)
+		} else {
+			return nil, err
+		}
+	} else {
+		logger.Debugf("Using cluster-override templates from %s", clusterTemplates)
+	}
+
+	merge(templates, overrides)
+
+	return templates, nil
+}
+
+func merge(dst, src map[string]string) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// directoryTemplates returns all files in a directory tree, keyed by their path relative to the directory.
+// Hidden files and directories are ignored, as are directories for which skipDir returns true.
+func directoryTemplates(directory string, skipDir func(relativePath string) bool) (map[string]string, error) {
+	files := make(map[string]string)
+
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == directory {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if entry.IsDir() {
+			if skipDir != nil && skipDir(relativePath) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		files[relativePath] = path
+		return nil
+	})
+
+	return files, err
+}
+
+// isPartial returns true for template files that only contain shared template definitions,
+// such as `_helpers.tpl`. Partials are available to every template, but not rendered themselves.
+func isPartial(relativePath string) bool {
+	return strings.HasPrefix(filepath.Base(relativePath), "_")
+}
+
+// splitPartials returns the paths of all partials, and the relative paths of all other templates, both sorted.
+// Partials are named by their file name when parsed, so two partials with the same file name are an error,
+// as one would silently replace the other.
+func splitPartials(templates map[string]string) ([]string, []string, error) {
+	partials := make([]string, 0)
+	filenames := make([]string, 0, len(templates))
+	for k, path := range templates {
+		if isPartial(k) {
+			partials = append(partials, path)
+			continue
+		}
+		filenames = append(filenames, k)
+	}
+	sort.Strings(partials)
+	sort.Strings(filenames)
+
+	seen := make(map[string]string, len(partials))
+	for _, path := range partials {
+		name := filepath.Base(path)
+		if other, ok := seen[name]; ok {
+			return nil, nil, fmt.Errorf("partials %s and %s have the same file name; rename one of them", other, path)
+		}
+		seen[name] = path
+	}
+
+	return partials, filenames, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitPartials(t *testing.T) {
+	partials, filenames, err := splitPartials(map[string]string{
+		"app.yaml":         "tpl/app.yaml",
+		"_helpers.tpl":     "tpl/_helpers.tpl",
+		"db/_db.tpl":       "tpl/db/_db.tpl",
+		"db/postgres.yaml": "tpl/clusters/prod-gcp/db/postgres.yaml",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tpl/_helpers.tpl", "tpl/db/_db.tpl"}, partials)
+	assert.Equal(t, []string{"app.yaml", "db/postgres.yaml"}, filenames)
+
+	_, _, err = splitPartials(map[string]string{
+		"a/_helpers.tpl": "tpl/a/_helpers.tpl",
+		"b/_helpers.tpl": "tpl/clusters/prod-gcp/b/_helpers.tpl",
+	})
+	assert.EqualError(t, err, "partials tpl/a/_helpers.tpl and tpl/clusters/prod-gcp/b/_helpers.tpl have the same file name; rename one of them")
+}
+
+func TestLegacyLayoutSkipsLayers(t *testing.T) {
+	variables := writeTemplates(t, map[string]string{
+		"global.yaml":      "",
+		"prod.yaml":        "",
+		"prod-gcp-1.yaml":  "",
+		"dev-gcp/app.yaml": "",
+		"layers.yaml":      "layers:\n  prod-gcp-1: [prod]\n",
+	})
+	templates := writeTemplates(t, map[string]string{
+		"app.yaml":            "",
+		"shared/config.yaml":  "",
+		"prod/app.yaml":       "",
+		"prod-gcp-1/app.yaml": "",
+		"dev-gcp/db.yaml":     "",
+	})
+
+	layout, err := newTemplateLayout(&config{templates: templates, variables: variables})
+	assert.NoError(t, err)
+
+	logger, _ := logtest.NewNullLogger()
+	files, err := layout.clusterTemplates("prod-gcp-1", logger)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"app.yaml":                             filepath.Join(templates, "prod-gcp-1", "app.yaml"),
+		filepath.Join("shared", "config.yaml"): filepath.Join(templates, "shared", "config.yaml"),
+	}, files)
+}
@@ -0,0 +1,249 @@
+// Package diff computes line-based differences and formats them as unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Line is one line of an edit script.
+// The text includes the trailing newline, if any.
+type Line struct {
+	Op   Op
+	Text string
+}
+
+// SplitLines splits text into lines, keeping the line endings.
+func SplitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns the shortest edit script transforming a into b,
+// computed with the linear space variant of the Myers difference algorithm.
+func Lines(a, b []string) []Line {
+	return compare(a, b, make([]Line, 0, len(a)+len(b)))
+}
+
+// compare appends the shortest edit script transforming a into b to result.
+// Common prefixes and suffixes, and inputs where one side is empty, are handled directly.
+// Otherwise the inputs are split at the middle snake of a shortest edit script, and both halves compared
+// recursively, so that memory use stays linear in the length of the inputs.
+func compare(a, b []string, result []Line) []Line {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, text := range a[:prefix] {
+		result = append(result, Line{Op: Equal, Text: text})
+	}
+
+	changedA, changedB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	switch {
+	case len(changedA) == 0:
+		for _, text := range changedB {
+			result = append(result, Line{Op: Insert, Text: text})
+		}
+	case len(changedB) == 0:
+		for _, text := range changedA {
+			result = append(result, Line{Op: Delete, Text: text})
+		}
+	default:
+		x, y, u, v := middleSnake(changedA, changedB)
+		result = compare(changedA[:x], changedB[:y], result)
+		for _, text := range changedA[x:u] {
+			result = append(result, Line{Op: Equal, Text: text})
+		}
+		result = compare(changedA[u:], changedB[v:], result)
+	}
+
+	for _, text := range a[len(a)-suffix:] {
+		result = append(result, Line{Op: Equal, Text: text})
+	}
+
+	return result
+}
+
+// middleSnake finds the middle snake of a shortest edit script transforming a into b, by searching
+// forward from the start and backward from the end at the same time until the paths overlap.
+// The snake goes from (x, y) to (u, v), and both a and b must be non-empty and differ in their first
+// and last lines, so that there are edits on both sides of the snake.
+func middleSnake(a, b []string) (int, int, int, int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	offset := max + 1
+
+	// forward[k] is the furthest reaching x on diagonal k = x - y from the start,
+	// backward[k] the furthest reaching x on diagonal k from the end, counted backwards.
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			if reverseK := delta - k; odd && reverseK >= -(d-1) && reverseK <= d-1 && x+backward[offset+reverseK] >= n {
+				return startX, startY, x, y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+
+			if forwardK := delta - k; !odd && forwardK >= -d && forwardK <= d && x+forward[offset+forwardK] >= n {
+				return n - x, m - y, n - startX, m - startY
+			}
+		}
+	}
+
+	// the paths always overlap within (n + m + 1) / 2 steps
+	panic("diff: no middle snake found")
+}
+
+// Unified returns a unified diff between two texts with the given number of context lines.
+// An empty string is returned if the texts are equal.
+func Unified(oldName, newName, oldText, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+
+	lines := Lines(SplitLines(oldText), SplitLines(newText))
+
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "--- %s\n", oldName)
+	fmt.Fprintf(buf, "+++ %s\n", newName)
+
+	for _, h := range hunks(lines, context) {
+		h.write(buf, lines)
+	}
+
+	return buf.String()
+}
+
+type hunk struct {
+	start, end         int // range in the edit script
+	oldStart, newStart int // zero-based line numbers
+	oldCount, newCount int
+}
+
+func hunks(lines []Line, context int) []hunk {
+	result := make([]hunk, 0)
+
+	for i, line := range lines {
+		if line.Op == Equal {
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i + 1 + context
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if n := len(result); n > 0 && start <= result[n-1].end {
+			result[n-1].end = end
+		} else {
+			result = append(result, hunk{start: start, end: end})
+		}
+	}
+
+	oldLine, newLine, next := 0, 0, 0
+	for i, line := range lines {
+		if next < len(result) && result[next].start == i {
+			result[next].oldStart, result[next].newStart = oldLine, newLine
+			next++
+		}
+		if line.Op != Insert {
+			oldLine++
+		}
+		if line.Op != Delete {
+			newLine++
+		}
+	}
+
+	for i := range result {
+		h := &result[i]
+		for _, line := range lines[h.start:h.end] {
+			if line.Op != Insert {
+				h.oldCount++
+			}
+			if line.Op != Delete {
+				h.newCount++
+			}
+		}
+	}
+
+	return result
+}
+
+func (h hunk) write(buf *strings.Builder, lines []Line) {
+	fmt.Fprintf(buf, "@@ -%s +%s @@\n", hunkRange(h.oldStart, h.oldCount), hunkRange(h.newStart, h.newCount))
+	for _, line := range lines[h.start:h.end] {
+		prefix := " "
+		switch line.Op {
+		case Insert:
+			prefix = "+"
+		case Delete:
+			prefix = "-"
+		}
+		buf.WriteString(prefix)
+		buf.WriteString(line.Text)
+		if !strings.HasSuffix(line.Text, "\n") {
+			buf.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
@@ -0,0 +1,165 @@
+package diff_test
+
+import (
+	"fmt"
+	"github.com/nais/naisplater/pkg/diff"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var unifiedTests = []struct {
+	old      string
+	new      string
+	expected string
+}{
+	{
+		"a\nb\nc\n",
+		"a\nb\nc\n",
+		"",
+	},
+	{
+		"",
+		"a\nb\n",
+		"--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+	},
+	{
+		"a\nb\n",
+		"",
+		"--- old\n+++ new\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+	},
+	{
+		"a\nb\nc\n",
+		"a\nx\nc\n",
+		"--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+	},
+	{
+		"a\nb",
+		"a\nb\n",
+		"--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+	},
+	{
+		"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+		"1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\neleven\n12\n",
+		"--- old\n+++ new\n@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n@@ -8,5 +8,5 @@\n 8\n 9\n 10\n-11\n+eleven\n 12\n",
+	},
+}
+
+func TestUnified(t *testing.T) {
+	for _, test := range unifiedTests {
+		assert.Equal(t, test.expected, diff.Unified("old", "new", test.old, test.new, 3))
+	}
+}
+
+func TestLinesIsMinimal(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+
+	edits := 0
+	for _, line := range diff.Lines(a, b) {
+		if line.Op != diff.Equal {
+			edits++
+		}
+	}
+	assert.Equal(t, 5, edits)
+}
+
+// Check that the edit script turns a into b, and is as short as the longest common subsequence allows.
+func TestLinesRandom(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a := make([]string, random.Intn(30))
+		for j := range a {
+			a[j] = string(rune('a' + random.Intn(4)))
+		}
+		b := make([]string, random.Intn(30))
+		for j := range b {
+			b[j] = string(rune('a' + random.Intn(4)))
+		}
+
+		oldLines, newLines := make([]string, 0), make([]string, 0)
+		edits := 0
+		for _, line := range diff.Lines(a, b) {
+			if line.Op != diff.Insert {
+				oldLines = append(oldLines, line.Text)
+			}
+			if line.Op != diff.Delete {
+				newLines = append(newLines, line.Text)
+			}
+			if line.Op != diff.Equal {
+				edits++
+			}
+		}
+		assert.Equal(t, a, oldLines)
+		assert.Equal(t, b, newLines)
+		assert.Equal(t, len(a)+len(b)-2*lcs(a, b), edits, "%v %v", a, b)
+	}
+}
+
+func lcs(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] > lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+	return lengths[0][0]
+}
+
+// Large generated files must not need memory or time quadratic in their length.
+func TestUnifiedLargeFiles(t *testing.T) {
+	lines := make([]string, 20000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d\n", i)
+	}
+	text := strings.Join(lines, "")
+
+	added := diff.Unified("/dev/null", "new", "", text, 3)
+	assert.Equal(t, "--- /dev/null\n+++ new\n@@ -0,0 +1,20000 @@\n+"+strings.Join(lines, "+"), added)
+
+	changed := strings.Replace(text, "line 10000\n", "line ten thousand\n", 1)
+	changed = strings.Replace(changed, "line 15000\n", "", 1) + "line 20000\n"
+	assert.Equal(t, "--- old\n+++ new\n"+
+		"@@ -9998,7 +9998,7 @@\n line 9997\n line 9998\n line 9999\n-line 10000\n+line ten thousand\n line 10001\n line 10002\n line 10003\n"+
+		"@@ -14998,7 +14998,6 @@\n line 14997\n line 14998\n line 14999\n-line 15000\n line 15001\n line 15002\n line 15003\n"+
+		"@@ -19998,3 +19997,4 @@\n line 19997\n line 19998\n line 19999\n+line 20000\n",
+		diff.Unified("old", "new", text, changed, 3))
+}
+
+// Check that applying the diff with patch(1), if available, reproduces the new text.
+func TestUnifiedAppliesWithPatch(t *testing.T) {
+	_, err := exec.LookPath("patch")
+	if err != nil {
+		t.Skip("patch not installed")
+	}
+
+	old := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: foo\ndata:\n  a: b\n  c: d\n  e: f\n"
+	new := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: bar\n  namespace: baz\ndata:\n  a: b\n  e: f\n  g: h\n"
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(old), 0644))
+
+	cmd := exec.Command("patch", path)
+	cmd.Stdin = strings.NewReader(diff.Unified("a/file.yaml", "b/file.yaml", old, new, 3))
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+
+	patched, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, new, string(patched))
+}
@@ -0,0 +1,166 @@
+// Package inventory keeps track of the files generated in an output directory,
+// so that files that are no longer generated can be found and removed.
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Filename of the inventory, relative to the output directory.
+// It has no .yaml extension, so that 'kubectl apply -f <directory>' does not try to apply it.
+const Filename = ".naisplater-inventory"
+
+// legacyFilename is where earlier versions wrote the inventory. It is read if there is no inventory,
+// and removed when the inventory is written.
+const legacyFilename = ".naisplater-inventory.yaml"
+
+const header = "# Files generated by naisplater. Used by --prune to remove files that are no longer generated.\n"
+
+type Inventory struct {
+	Files []string `yaml:"files"`
+}
+
+// New creates an inventory of files, given as paths relative to the output directory.
+func New(files []string) *Inventory {
+	inv := &Inventory{
+		Files: make([]string, 0, len(files)),
+	}
+	for _, file := range files {
+		inv.Files = append(inv.Files, filepath.ToSlash(file))
+	}
+	inv.normalize()
+	return inv
+}
+
+func (inv *Inventory) normalize() {
+	seen := make(map[string]bool)
+	files := make([]string, 0, len(inv.Files))
+	for _, file := range inv.Files {
+		if !seen[file] {
+			files = append(files, file)
+			seen[file] = true
+		}
+	}
+	sort.Strings(files)
+	inv.Files = files
+}
+
+// Read the inventory of an output directory. If there is no inventory, an empty inventory is returned.
+func Read(directory string) (*Inventory, error) {
+	inv := &Inventory{}
+
+	path := filepath.Join(directory, Filename)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		path = filepath.Join(directory, legacyFilename)
+		data, err = os.ReadFile(path)
+	}
+	if os.IsNotExist(err) {
+		return New(nil), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, inv)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, file := range inv.Files {
+		err = validPath(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	inv.normalize()
+
+	return inv, nil
+}
+
+// Write the inventory into an output directory.
+func (inv *Inventory) Write(directory string) error {
+	buf := bytes.NewBufferString(header)
+	err := yaml.NewEncoder(buf).Encode(inv)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(directory, Filename), buf.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filepath.Join(directory, legacyFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Merge adds all files in another inventory to this one.
+func (inv *Inventory) Merge(other *Inventory) {
+	inv.Files = append(inv.Files, other.Files...)
+	inv.normalize()
+}
+
+// Stale returns files in this inventory that are not in the current inventory.
+func (inv *Inventory) Stale(current *Inventory) []string {
+	generated := make(map[string]bool)
+	for _, file := range current.Files {
+		generated[file] = true
+	}
+
+	stale := make([]string, 0)
+	for _, file := range inv.Files {
+		if !generated[file] {
+			stale = append(stale, file)
+		}
+	}
+
+	return stale
+}
+
+// Remove deletes files from the output directory, along with any directories left empty.
+// Files that have already been removed are ignored.
+func Remove(directory string, files []string) error {
+	for _, file := range files {
+		err := validPath(file)
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(directory, filepath.FromSlash(file))
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		for dir := filepath.Dir(path); dir != filepath.Clean(directory); dir = filepath.Dir(dir) {
+			entries, err := os.ReadDir(dir)
+			if err != nil || len(entries) > 0 {
+				break
+			}
+			err = os.Remove(dir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validPath guards against removing files outside of the output directory.
+func validPath(file string) error {
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(file)))
+	if len(file) == 0 || filepath.IsAbs(filepath.FromSlash(file)) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid path '%s' in inventory", file)
+	}
+	return nil
+}
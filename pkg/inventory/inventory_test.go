@@ -0,0 +1,85 @@
+package inventory_test
+
+import (
+	"github.com/nais/naisplater/pkg/inventory"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadMissingInventory(t *testing.T) {
+	inv, err := inventory.Read(t.TempDir())
+	assert.NoError(t, err)
+	assert.Empty(t, inv.Files)
+}
+
+func TestWriteAndRead(t *testing.T) {
+	dir := t.TempDir()
+	inv := inventory.New([]string{"b.yaml", filepath.Join("apps", "a.yaml"), "b.yaml"})
+
+	err := inv.Write(dir)
+	assert.NoError(t, err)
+
+	read, err := inventory.Read(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apps/a.yaml", "b.yaml"}, read.Files)
+
+	// kubectl applies all .json, .yaml and .yml files in a directory, so the inventory has no extension
+	_, err = os.Stat(filepath.Join(dir, ".naisplater-inventory"))
+	assert.NoError(t, err)
+}
+
+func TestReadLegacyInventory(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, ".naisplater-inventory.yaml")
+	assert.NoError(t, os.WriteFile(legacy, []byte("files:\n- a.yaml\n"), 0644))
+
+	inv, err := inventory.Read(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.yaml"}, inv.Files)
+
+	err = inv.Write(dir)
+	assert.NoError(t, err)
+	_, err = os.Stat(legacy)
+	assert.True(t, os.IsNotExist(err), "the legacy inventory is removed")
+
+	inv, err = inventory.Read(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.yaml"}, inv.Files)
+}
+
+func TestStale(t *testing.T) {
+	previous := inventory.New([]string{"a.yaml", "b.yaml", "c.yaml"})
+	current := inventory.New([]string{"a.yaml", "c.yaml", "d.yaml"})
+
+	assert.Equal(t, []string{"b.yaml"}, previous.Stale(current))
+
+	current.Merge(previous)
+	assert.Equal(t, []string{"a.yaml", "b.yaml", "c.yaml", "d.yaml"}, current.Files)
+}
+
+func TestRemove(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "apps", "nested")
+	assert.NoError(t, os.MkdirAll(nested, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(nested, "a.yaml"), nil, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), nil, 0644))
+
+	err := inventory.Remove(dir, []string{"apps/nested/a.yaml", "missing.yaml"})
+	assert.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "apps"))
+	assert.True(t, os.IsNotExist(err), "empty directories are removed")
+	_, err = os.Stat(filepath.Join(dir, "b.yaml"))
+	assert.NoError(t, err)
+}
+
+func TestRemoveOutsideDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{"../escape.yaml", "/etc/passwd", "apps/../../escape.yaml", ""} {
+		err := inventory.Remove(dir, []string{path})
+		assert.Error(t, err, path)
+	}
+}
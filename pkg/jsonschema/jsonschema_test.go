@@ -0,0 +1,153 @@
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"github.com/nais/naisplater/pkg/jsonschema"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const deployment = `{
+  "type": "object",
+  "required": ["spec"],
+  "additionalProperties": false,
+  "properties": {
+    "kind": {"type": "string", "enum": ["Deployment"]},
+    "metadata": {
+      "type": "object",
+      "properties": {
+        "name": {"type": "string", "maxLength": 8, "pattern": "^[a-z-]+$"},
+        "labels": {"type": "object", "additionalProperties": {"type": "string"}}
+      }
+    },
+    "spec": {
+      "type": "object",
+      "additionalProperties": false,
+      "required": ["containers"],
+      "properties": {
+        "replicas": {"type": "integer", "minimum": 0},
+        "containers": {"type": "array", "minItems": 1, "items": {"$ref": "#/definitions/Container"}},
+        "extra": {"x-kubernetes-preserve-unknown-fields": true, "type": "object", "additionalProperties": false}
+      }
+    }
+  },
+  "definitions": {
+    "Container": {
+      "type": "object",
+      "additionalProperties": false,
+      "required": ["name"],
+      "properties": {
+        "name": {"type": "string"},
+        "port": {"x-kubernetes-int-or-string": true},
+        "ratio": {"type": ["number", "null"]},
+        "pullPolicy": {"anyOf": [{"enum": ["Always"]}, {"enum": ["Never"]}]}
+      }
+    }
+  }
+}`
+
+var validateTests = []struct {
+	document string
+	errors   []string
+}{
+	{
+		document: `{"kind": "Deployment", "metadata": {"name": "app", "labels": {"nais.io/team": "aura"}}, "spec": {"replicas": 2, "containers": [{"name": "app", "port": "http", "ratio": 0.5}]}}`,
+		errors:   []string{},
+	},
+	{
+		document: `{"kind": "Service", "spec": {"replicas": "3", "contianers": []}}`,
+		errors: []string{
+			"kind: must be one of: Deployment",
+			"spec.containers: required field is missing",
+			"spec.contianers: unknown field",
+			"spec.replicas: expected integer, got string",
+		},
+	},
+	{
+		document: `{"metadata": {"name": "Too-Long-Name", "labels": {"nais.io/team": 1}}, "spec": {"replicas": -1.5, "containers": [{"port": 1.5, "ratio": "x", "pullPolicy": "Sometimes"}], "extra": {"anything": true}}}`,
+		errors: []string{
+			`metadata.labels["nais.io/team"]: expected string, got integer`,
+			"metadata.name: must be at most 8 characters long",
+			"metadata.name: must match pattern '^[a-z-]+$'",
+			"spec.containers[0].name: required field is missing",
+			"spec.containers[0].port: expected integer or string, got number",
+			"spec.containers[0].pullPolicy: does not match any of the allowed schemas",
+			"spec.containers[0].ratio: expected number or null, got string",
+			"spec.replicas: expected integer, got number",
+		},
+	},
+	{
+		document: `{"kind": null, "metadata": {"name": null, "labels": null}, "spec": {"replicas": null, "containers": [{"name": "app", "port": null}]}}`,
+		errors:   []string{},
+	},
+	{
+		document: `{"spec": {"containers": null}}`,
+		errors:   []string{"spec.containers: expected array, got null"},
+	},
+	{
+		document: `{"spec": {"containers": []}, "status": {}}`,
+		errors: []string{
+			"spec.containers: must have at least 1 items",
+			"status: unknown field",
+		},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := jsonschema.Parse([]byte(deployment))
+	assert.NoError(t, err)
+
+	for _, test := range validateTests {
+		var document interface{}
+		err := json.Unmarshal([]byte(test.document), &document)
+		assert.NoError(t, err)
+
+		errors := make([]string, 0)
+		for _, e := range schema.Validate(document) {
+			errors = append(errors, e.Error())
+		}
+		assert.Equal(t, test.errors, errors, test.document)
+	}
+}
+
+func TestValidateNativeIntegers(t *testing.T) {
+	schema, err := jsonschema.Parse([]byte(`{"type": "integer", "maximum": 10}`))
+	assert.NoError(t, err)
+	assert.True(t, schema.Valid(3))
+	assert.True(t, schema.Valid(uint64(10)))
+	assert.Equal(t, []jsonschema.Error{{Path: "", Message: "must be less than or equal to 10"}}, schema.Validate(int64(11)))
+}
+
+func TestLoader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"service.json": {Data: []byte(`{"properties": {"metadata": {"$ref": "defs/_definitions.json#/definitions/ObjectMeta"}}}`)},
+		"defs/_definitions.json": {Data: []byte(`{"definitions": {
+			"ObjectMeta": {"type": "object", "properties": {"name": {"type": "string"}, "owner": {"$ref": "#/definitions/ObjectMeta"}}}
+		}}`)},
+		"broken.json":  {Data: []byte(`{"$ref": "#/definitions/Missing"}`)},
+		"pattern.json": {Data: []byte(`{"properties": {"metadata": {"properties": {"name": {"pattern": "^(?!kube-)"}}}}}`)},
+	}
+
+	loader := jsonschema.NewLoader(fsys)
+	schema, err := loader.Load("service.json")
+	assert.NoError(t, err)
+
+	var document interface{}
+	err = json.Unmarshal([]byte(`{"metadata": {"name": "a", "owner": {"name": 1}}}`), &document)
+	assert.NoError(t, err)
+	assert.Equal(t, []jsonschema.Error{{Path: "metadata.owner.name", Message: "expected string, got integer"}}, schema.Validate(document))
+
+	_, err = loader.Load("broken.json")
+	assert.EqualError(t, err, "broken.json: $ref '#/definitions/Missing': pointer '/definitions/Missing' not found")
+
+	_, err = loader.Load("pattern.json")
+	assert.EqualError(t, err, "pattern.json: #/properties/metadata/properties/name: invalid pattern '^(?!kube-)': error parsing regexp: invalid or unsupported Perl syntax: `(?!`")
+
+	_, err = jsonschema.Parse([]byte(`{"$ref": "other.json"}`))
+	assert.EqualError(t, err, "$ref 'other.json': references to other files are not supported")
+
+	_, err = jsonschema.Parse([]byte(`{"definitions": {"a/b": {"allOf": [{}, {"pattern": "[a-"}]}}}`))
+	assert.EqualError(t, err, "#/definitions/a~1b/allOf/1: invalid pattern '[a-': error parsing regexp: missing closing ]: `[a-`")
+}
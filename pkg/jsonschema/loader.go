@@ -0,0 +1,105 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Loader loads schema files from a file system, and resolves references between them.
+// Every file is parsed only once. A Loader is not safe for concurrent use.
+type Loader struct {
+	fsys  fs.FS
+	files map[string]*Schema
+}
+
+func NewLoader(fsys fs.FS) *Loader {
+	return &Loader{
+		fsys:  fsys,
+		files: make(map[string]*Schema),
+	}
+}
+
+// Load returns the schema in the named file, with all references resolved.
+func (l *Loader) Load(name string) (*Schema, error) {
+	name = path.Clean(name)
+	if s, ok := l.files[name]; ok {
+		return s, nil
+	}
+
+	data, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Schema{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	// register before resolving, so that files can reference each other
+	l.files[name] = s
+
+	err = l.resolve(s, name, s, "")
+	if err != nil {
+		delete(l.files, name)
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return s, nil
+}
+
+// resolve resolves references and compiles patterns in a schema and all its subschemas.
+// The pointer is the location of the schema in its file, for error messages.
+// A nil Loader can only resolve references within the same file.
+func (l *Loader) resolve(s *Schema, file string, root *Schema, pointer string) error {
+	if len(s.Ref) > 0 {
+		target, err := l.lookup(s.Ref, file, root)
+		if err != nil {
+			return fmt.Errorf("$ref '%s': %w", s.Ref, err)
+		}
+		s.ref = target
+	}
+
+	if len(s.Pattern) > 0 {
+		var err error
+		s.pattern, err = regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("#%s: invalid pattern '%s': %w", pointer, s.Pattern, err)
+		}
+	}
+
+	for _, sub := range s.subschemas() {
+		err := l.resolve(sub.schema, file, root, pointer+sub.pointer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Loader) lookup(ref, file string, root *Schema) (*Schema, error) {
+	name, pointer := ref, ""
+	if i := strings.Index(ref, "#"); i >= 0 {
+		name, pointer = ref[:i], ref[i+1:]
+	}
+
+	target := root
+	if len(name) > 0 {
+		if l == nil {
+			return nil, fmt.Errorf("references to other files are not supported")
+		}
+		var err error
+		target, err = l.Load(path.Join(path.Dir(file), name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return target.pointer(pointer)
+}
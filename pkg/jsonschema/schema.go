@@ -0,0 +1,192 @@
+// Package jsonschema validates JSON values against JSON schemas.
+//
+// Only the subset of JSON Schema used by Kubernetes OpenAPI and CustomResourceDefinition schemas is supported:
+// type, enum, const, properties, required, additionalProperties, items, length, size and range constraints,
+// pattern, allOf, anyOf, oneOf, not, and references to definitions in the same or other schema files.
+// The Kubernetes extensions nullable, x-kubernetes-int-or-string and x-kubernetes-preserve-unknown-fields are honored.
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is a parsed JSON schema. Unsupported keywords are ignored.
+type Schema struct {
+	Ref                   string             `json:"$ref"`
+	Definitions           map[string]*Schema `json:"definitions"`
+	Defs                  map[string]*Schema `json:"$defs"`
+	Type                  Types              `json:"type"`
+	Format                string             `json:"format"`
+	Enum                  []interface{}      `json:"enum"`
+	Const                 interface{}        `json:"const"`
+	Properties            map[string]*Schema `json:"properties"`
+	Required              []string           `json:"required"`
+	AdditionalProperties  *Schema            `json:"additionalProperties"`
+	Items                 *Schema            `json:"items"`
+	MinItems              *int               `json:"minItems"`
+	MaxItems              *int               `json:"maxItems"`
+	MinLength             *int               `json:"minLength"`
+	MaxLength             *int               `json:"maxLength"`
+	MinProperties         *int               `json:"minProperties"`
+	MaxProperties         *int               `json:"maxProperties"`
+	Minimum               *float64           `json:"minimum"`
+	Maximum               *float64           `json:"maximum"`
+	ExclusiveMinimum      *float64           `json:"exclusiveMinimum"`
+	ExclusiveMaximum      *float64           `json:"exclusiveMaximum"`
+	Pattern               string             `json:"pattern"`
+	AllOf                 []*Schema          `json:"allOf"`
+	AnyOf                 []*Schema          `json:"anyOf"`
+	OneOf                 []*Schema          `json:"oneOf"`
+	Not                   *Schema            `json:"not"`
+	Nullable              bool               `json:"nullable"`
+	IntOrString           bool               `json:"x-kubernetes-int-or-string"`
+	PreserveUnknownFields bool               `json:"x-kubernetes-preserve-unknown-fields"`
+
+	// never is set for the boolean schema 'false', which no value is valid against.
+	never   bool
+	ref     *Schema
+	pattern *regexp.Regexp
+}
+
+// Types holds the allowed types of a value, which is either a single type or a list of types in JSON.
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*t = Types{single}
+		return nil
+	}
+	var list []string
+	err := json.Unmarshal(data, &list)
+	if err != nil {
+		return fmt.Errorf("type must be a string or a list of strings")
+	}
+	*t = list
+	return nil
+}
+
+// UnmarshalJSON parses a schema, including the boolean schemas 'true' and 'false'.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch strings.TrimSpace(string(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{never: true}
+		return nil
+	}
+
+	type schema Schema
+	return json.Unmarshal(data, (*schema)(s))
+}
+
+// Parse parses a schema that only has references to its own definitions.
+func Parse(data []byte) (*Schema, error) {
+	s := &Schema{}
+	err := json.Unmarshal(data, s)
+	if err != nil {
+		return nil, err
+	}
+
+	err = (*Loader)(nil).resolve(s, "", s, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// subschema is a schema directly contained in another, at a JSON pointer relative to it, such as '/properties/name'.
+type subschema struct {
+	pointer string
+	schema  *Schema
+}
+
+// subschemas returns all schemas directly contained in s, in a stable order.
+func (s *Schema) subschemas() []subschema {
+	result := make([]subschema, 0)
+	for _, field := range []struct {
+		name    string
+		schemas map[string]*Schema
+	}{{"definitions", s.Definitions}, {"$defs", s.Defs}, {"properties", s.Properties}} {
+		names := make([]string, 0, len(field.schemas))
+		for name := range field.schemas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			result = append(result, subschema{"/" + field.name + "/" + escape(name), field.schemas[name]})
+		}
+	}
+	for _, field := range []struct {
+		name   string
+		schema *Schema
+	}{{"additionalProperties", s.AdditionalProperties}, {"items", s.Items}, {"not", s.Not}} {
+		if field.schema != nil {
+			result = append(result, subschema{"/" + field.name, field.schema})
+		}
+	}
+	for _, field := range []struct {
+		name    string
+		schemas []*Schema
+	}{{"allOf", s.AllOf}, {"anyOf", s.AnyOf}, {"oneOf", s.OneOf}} {
+		for i, sub := range field.schemas {
+			result = append(result, subschema{fmt.Sprintf("/%s/%d", field.name, i), sub})
+		}
+	}
+	return result
+}
+
+// pointer returns the subschema at a JSON pointer such as '/definitions/Container'.
+func (s *Schema) pointer(pointer string) (*Schema, error) {
+	current := s
+	tokens := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	if len(pointer) == 0 {
+		tokens = nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		token := unescape(tokens[i])
+		var next *Schema
+		switch token {
+		case "definitions", "$defs", "properties":
+			if i+1 == len(tokens) {
+				return nil, fmt.Errorf("invalid pointer '%s'", pointer)
+			}
+			i++
+			switch token {
+			case "definitions":
+				next = current.Definitions[unescape(tokens[i])]
+			case "$defs":
+				next = current.Defs[unescape(tokens[i])]
+			default:
+				next = current.Properties[unescape(tokens[i])]
+			}
+		case "items":
+			next = current.Items
+		case "additionalProperties":
+			next = current.AdditionalProperties
+		case "not":
+			next = current.Not
+		}
+		if next == nil {
+			return nil, fmt.Errorf("pointer '%s' not found", pointer)
+		}
+		current = next
+	}
+
+	return current, nil
+}
+
+func unescape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
+
+func escape(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
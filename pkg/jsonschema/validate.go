@@ -0,0 +1,304 @@
+package jsonschema
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is a schema violation at a path in the validated value, such as 'spec.containers[0].image'.
+type Error struct {
+	Path    string
+	Message string
+}
+
+func (e Error) Error() string {
+	if len(e.Path) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// Validate validates a value and returns all violations, ordered by their position in the value.
+// Objects must be map[string]interface{} and arrays []interface{}, like values decoded by encoding/json.
+// Numbers can be of any integer or floating point type.
+func (s *Schema) Validate(value interface{}) []Error {
+	errors := make([]Error, 0)
+	s.validate(value, "", &errors)
+	return errors
+}
+
+// Valid returns true if the value is valid against the schema.
+func (s *Schema) Valid(value interface{}) bool {
+	return len(s.Validate(value)) == 0
+}
+
+func (s *Schema) validate(value interface{}, path string, errors *[]Error) {
+	fail := func(format string, args ...interface{}) {
+		*errors = append(*errors, Error{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.ref != nil {
+		s.ref.validate(value, path, errors)
+		return
+	}
+
+	if s.never {
+		fail("not allowed")
+		return
+	}
+
+	if value == nil && s.Nullable {
+		return
+	}
+
+	if !s.validType(value) {
+		fail("expected %s, got %s", s.expectedType(), typeOf(value))
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if equal(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			values := make([]string, len(s.Enum))
+			for i := range s.Enum {
+				values[i] = fmt.Sprintf("%v", s.Enum[i])
+			}
+			fail("must be one of: %s", strings.Join(values, ", "))
+		}
+	}
+
+	if s.Const != nil && !equal(s.Const, value) {
+		fail("must be %v", s.Const)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, errors)
+	case []interface{}:
+		s.validateArray(v, path, errors)
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match pattern '%s'", s.Pattern)
+		}
+	default:
+		if n, ok := number(value); ok {
+			s.validateNumber(n, fail)
+		}
+	}
+
+	for _, sub := range s.AllOf {
+		sub.validate(value, path, errors)
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, sub := range s.AnyOf {
+			if sub.Valid(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any of the allowed schemas")
+		}
+	}
+
+	if len(s.OneOf) > 0 {
+		matches := 0
+		for _, sub := range s.OneOf {
+			if sub.Valid(value) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("must match exactly one of the allowed schemas, matched %d", matches)
+		}
+	}
+
+	if s.Not != nil && s.Not.Valid(value) {
+		fail("matches a schema that is not allowed")
+	}
+}
+
+func (s *Schema) validateObject(object map[string]interface{}, path string, errors *[]Error) {
+	required := make(map[string]bool, len(s.Required))
+	for _, name := range s.Required {
+		required[name] = true
+		if _, ok := object[name]; !ok {
+			*errors = append(*errors, Error{Path: join(path, name), Message: "required field is missing"})
+		}
+	}
+
+	if s.MinProperties != nil && len(object) < *s.MinProperties {
+		*errors = append(*errors, Error{Path: path, Message: fmt.Sprintf("must have at least %d fields", *s.MinProperties)})
+	}
+	if s.MaxProperties != nil && len(object) > *s.MaxProperties {
+		*errors = append(*errors, Error{Path: path, Message: fmt.Sprintf("must have at most %d fields", *s.MaxProperties)})
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := join(path, name)
+		if property, ok := s.Properties[name]; ok {
+			// like the Kubernetes API server, null is the same as leaving out a field that is not required,
+			// such as 'annotations:' without any annotations
+			if object[name] == nil && !required[name] {
+				continue
+			}
+			property.validate(object[name], child, errors)
+			continue
+		}
+		if s.AdditionalProperties == nil || s.PreserveUnknownFields {
+			continue
+		}
+		if s.AdditionalProperties.never {
+			*errors = append(*errors, Error{Path: child, Message: "unknown field"})
+			continue
+		}
+		s.AdditionalProperties.validate(object[name], child, errors)
+	}
+}
+
+func (s *Schema) validateArray(array []interface{}, path string, errors *[]Error) {
+	if s.MinItems != nil && len(array) < *s.MinItems {
+		*errors = append(*errors, Error{Path: path, Message: fmt.Sprintf("must have at least %d items", *s.MinItems)})
+	}
+	if s.MaxItems != nil && len(array) > *s.MaxItems {
+		*errors = append(*errors, Error{Path: path, Message: fmt.Sprintf("must have at most %d items", *s.MaxItems)})
+	}
+	if s.Items == nil {
+		return
+	}
+	for i, item := range array {
+		s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errors)
+	}
+}
+
+func (s *Schema) validateNumber(n float64, fail func(string, ...interface{})) {
+	if s.Minimum != nil && n < *s.Minimum {
+		fail("must be greater than or equal to %v", *s.Minimum)
+	}
+	if s.Maximum != nil && n > *s.Maximum {
+		fail("must be less than or equal to %v", *s.Maximum)
+	}
+	if s.ExclusiveMinimum != nil && n <= *s.ExclusiveMinimum {
+		fail("must be greater than %v", *s.ExclusiveMinimum)
+	}
+	if s.ExclusiveMaximum != nil && n >= *s.ExclusiveMaximum {
+		fail("must be less than %v", *s.ExclusiveMaximum)
+	}
+}
+
+func (s *Schema) intOrString() bool {
+	return s.IntOrString || s.Format == "int-or-string"
+}
+
+func (s *Schema) validType(value interface{}) bool {
+	if s.intOrString() {
+		_, isString := value.(string)
+		return isString || isInteger(value)
+	}
+	if len(s.Type) == 0 {
+		return true
+	}
+	for _, t := range s.Type {
+		if t == typeOf(value) || (t == "number" && typeOf(value) == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Schema) expectedType() string {
+	if s.intOrString() {
+		return "integer or string"
+	}
+	return strings.Join(s.Type, " or ")
+}
+
+// typeOf returns the JSON schema type of a value.
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	if isInteger(value) {
+		return "integer"
+	}
+	if _, ok := number(value); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func number(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func isInteger(value interface{}) bool {
+	n, ok := number(value)
+	return ok && n == math.Trunc(n) && !math.IsInf(n, 0)
+}
+
+// equal compares values for enum and const, treating all numbers alike.
+func equal(a, b interface{}) bool {
+	an, aok := number(a)
+	bn, bok := number(b)
+	if aok && bok {
+		return an == bn
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// join appends a field name to a path, quoting names that are not plain identifiers, such as 'nais.io/team'.
+func join(path, name string) string {
+	if !identifier.MatchString(name) {
+		return fmt.Sprintf("%s[%q]", path, name)
+	}
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
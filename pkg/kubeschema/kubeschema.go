@@ -0,0 +1,255 @@
+// Package kubeschema validates Kubernetes resources against JSON schemas, without access to a cluster.
+//
+// Schemas for common built-in kinds are bundled. Additional schemas are read from a directory,
+// either as JSON schema files named after the kind, group and version, such as 'application-nais.io-v1alpha1.json',
+// or as CustomResourceDefinition manifests, whose OpenAPI schemas are used for every version they define.
+// Schemas in the directory take precedence over bundled schemas.
+package kubeschema
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nais/naisplater/pkg/jsonschema"
+	"github.com/nais/naisplater/pkg/templatetools"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//go:embed schemas/*.json
+var bundled embed.FS
+
+// ErrNoSchema is returned when there is no schema for a resource.
+var ErrNoSchema = errors.New("no schema found")
+
+// Validator validates resources against bundled and user-supplied schemas.
+// It is safe for concurrent use.
+type Validator struct {
+	sources []source
+	crds    map[string]*jsonschema.Schema
+	lock    sync.Mutex
+}
+
+// source is a file system with schema files.
+type source struct {
+	fsys   fs.FS
+	loader *jsonschema.Loader
+}
+
+func newSource(fsys fs.FS) source {
+	return source{
+		fsys:   fsys,
+		loader: jsonschema.NewLoader(fsys),
+	}
+}
+
+// New returns a validator with the bundled schemas, and the schemas in directory unless it is empty.
+func New(directory string) (*Validator, error) {
+	schemas, err := fs.Sub(bundled, "schemas")
+	if err != nil {
+		return nil, err
+	}
+
+	v := &Validator{
+		sources: []source{newSource(schemas)},
+		crds:    make(map[string]*jsonschema.Schema),
+	}
+
+	if len(directory) == 0 {
+		return v, nil
+	}
+
+	v.sources = append([]source{newSource(os.DirFS(directory))}, v.sources...)
+
+	err = filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !(strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml")) {
+			return nil
+		}
+		return v.readCustomResourceDefinitions(path)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read schemas: %w", err)
+	}
+
+	return v, nil
+}
+
+// Filename returns the name of the schema file for a kind, such as 'deployment-apps-v1.json' for 'apps/v1' Deployment
+// or 'service-v1.json' for 'v1' Service.
+func Filename(apiVersion, kind string) string {
+	parts := []string{strings.ToLower(kind)}
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		parts = append(parts, strings.ToLower(apiVersion[:i]))
+		apiVersion = apiVersion[i+1:]
+	}
+	parts = append(parts, strings.ToLower(apiVersion))
+	return strings.Join(parts, "-") + ".json"
+}
+
+// readCustomResourceDefinitions registers the schemas of all CustomResourceDefinitions in a YAML file.
+func (v *Validator) readCustomResourceDefinitions(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var crd struct {
+			Kind string `yaml:"kind"`
+			Spec struct {
+				Group string `yaml:"group"`
+				Names struct {
+					Kind string `yaml:"kind"`
+				} `yaml:"names"`
+				Versions []struct {
+					Name   string `yaml:"name"`
+					Schema struct {
+						OpenAPIV3Schema interface{} `yaml:"openAPIV3Schema"`
+					} `yaml:"schema"`
+				} `yaml:"versions"`
+			} `yaml:"spec"`
+		}
+
+		err = decoder.Decode(&crd)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if crd.Kind != "CustomResourceDefinition" {
+			continue
+		}
+
+		for _, version := range crd.Spec.Versions {
+			if version.Schema.OpenAPIV3Schema == nil {
+				continue
+			}
+			data, err := json.Marshal(JSONValue(version.Schema.OpenAPIV3Schema))
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			schema, err := jsonschema.Parse(data)
+			if err != nil {
+				return fmt.Errorf("%s: %s %s: %w", path, crd.Spec.Names.Kind, version.Name, err)
+			}
+			v.crds[Filename(crd.Spec.Group+"/"+version.Name, crd.Spec.Names.Kind)] = schema
+		}
+	}
+}
+
+// schema returns the schema for a kind. Schema files take precedence over CustomResourceDefinitions.
+func (v *Validator) schema(apiVersion, kind string) (*jsonschema.Schema, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	filename := Filename(apiVersion, kind)
+
+	for _, source := range v.sources {
+		_, err := fs.Stat(source.fsys, filename)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return source.loader.Load(filename)
+	}
+
+	if schema, ok := v.crds[filename]; ok {
+		return schema, nil
+	}
+
+	return nil, fmt.Errorf("%w for %s %s", ErrNoSchema, apiVersion, kind)
+}
+
+// Validate validates a resource, such as a document decoded by yaml.v2.
+// Items of List resources are validated individually.
+func (v *Validator) Validate(resource interface{}) ([]jsonschema.Error, error) {
+	value := JSONValue(resource)
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return []jsonschema.Error{{Message: "resource is not an object"}}, nil
+	}
+
+	kind, _ := object["kind"].(string)
+	items, ok := object["items"].([]interface{})
+	if !ok || !strings.HasSuffix(kind, "List") {
+		return v.validate(object, "")
+	}
+
+	result := make([]jsonschema.Error, 0)
+	for i, item := range items {
+		path := fmt.Sprintf("items[%d]", i)
+		resource, ok := item.(map[string]interface{})
+		if !ok {
+			result = append(result, jsonschema.Error{Path: path, Message: "resource is not an object"})
+			continue
+		}
+		errs, err := v.validate(resource, path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		result = append(result, errs...)
+	}
+
+	return result, nil
+}
+
+func (v *Validator) validate(resource map[string]interface{}, path string) ([]jsonschema.Error, error) {
+	apiVersion, _ := resource["apiVersion"].(string)
+	kind, _ := resource["kind"].(string)
+	if len(apiVersion) == 0 || len(kind) == 0 {
+		return []jsonschema.Error{{Path: path, Message: "apiVersion and kind are required"}}, nil
+	}
+
+	schema, err := v.schema(apiVersion, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := schema.Validate(resource)
+	if len(path) > 0 {
+		for i := range errs {
+			if len(errs[i].Path) == 0 || strings.HasPrefix(errs[i].Path, "[") {
+				errs[i].Path = path + errs[i].Path
+			} else {
+				errs[i].Path = path + "." + errs[i].Path
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// JSONValue converts a value decoded by yaml.v2, such as variables, into a value as decoded by encoding/json,
+// where all maps have string keys.
+func JSONValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case templatetools.Variables:
+		return JSONValue(map[interface{}]interface{}(v))
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = JSONValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = JSONValue(item)
+		}
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,229 @@
+package kubeschema_test
+
+import (
+	"errors"
+	"github.com/nais/naisplater/pkg/kubeschema"
+	"github.com/nais/naisplater/pkg/templatetools"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const crd = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: applications.nais.io
+spec:
+  group: nais.io
+  names:
+    kind: Application
+  versions:
+  - name: v1alpha1
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            required: [image]
+            properties:
+              image:
+                type: string
+              replicas:
+                type: object
+                properties:
+                  min:
+                    type: integer
+`
+
+func decode(t *testing.T, document string) interface{} {
+	var resource interface{}
+	err := yaml.Unmarshal([]byte(document), &resource)
+	assert.NoError(t, err)
+	return resource
+}
+
+func validate(t *testing.T, v *kubeschema.Validator, document string) []string {
+	errs, err := v.Validate(decode(t, document))
+	assert.NoError(t, err)
+	messages := make([]string, 0)
+	for _, e := range errs {
+		messages = append(messages, e.Error())
+	}
+	return messages
+}
+
+func TestFilename(t *testing.T) {
+	assert.Equal(t, "deployment-apps-v1.json", kubeschema.Filename("apps/v1", "Deployment"))
+	assert.Equal(t, "service-v1.json", kubeschema.Filename("v1", "Service"))
+	assert.Equal(t, "ingress-networking.k8s.io-v1.json", kubeschema.Filename("networking.k8s.io/v1", "Ingress"))
+}
+
+func TestBundledSchemas(t *testing.T) {
+	v, err := kubeschema.New("")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{}, validate(t, v, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  labels:
+    nais.io/created-by: nais-yaml
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: app
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx
+        ports:
+        - containerPort: 8080
+        resources:
+          limits:
+            cpu: 500m
+            memory: 1
+`))
+
+	assert.Equal(t, []string{
+		"spec.replicas: expected integer, got string",
+		"spec.template.spec.containers: required field is missing",
+		"spec.template.spec.contianers: unknown field",
+	}, validate(t, v, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  replicas: "3"
+  selector: {}
+  template:
+    spec:
+      contianers: []
+`))
+}
+
+func TestBundledSchemasNullFields(t *testing.T) {
+	v, err := kubeschema.New("")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{}, validate(t, v, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+  annotations:
+spec:
+  selector:
+    matchLabels:
+      app: app
+  template:
+    spec:
+      containers:
+      - name: app
+        image: nginx
+        env:
+`))
+
+	assert.Equal(t, []string{"spec.template.spec.containers: expected array, got null"}, validate(t, v, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: app
+spec:
+  selector: {}
+  template:
+    spec:
+      containers:
+`))
+}
+
+func TestJSONValue(t *testing.T) {
+	for _, test := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{"app", "app"},
+		{nil, nil},
+		{[]interface{}{1, "two"}, []interface{}{1, "two"}},
+		{
+			map[interface{}]interface{}{"replicas": 3, 8080: "http"},
+			map[string]interface{}{"replicas": 3, "8080": "http"},
+		},
+		{
+			templatetools.Variables{
+				"image":      templatetools.Variables{"tag": "1.21"},
+				"containers": []interface{}{templatetools.Variables{"name": "app"}},
+				true:         map[interface{}]interface{}{"nested": []interface{}{}},
+			},
+			map[string]interface{}{
+				"image":      map[string]interface{}{"tag": "1.21"},
+				"containers": []interface{}{map[string]interface{}{"name": "app"}},
+				"true":       map[string]interface{}{"nested": []interface{}{}},
+			},
+		},
+	} {
+		assert.Equal(t, test.expected, kubeschema.JSONValue(test.value))
+	}
+}
+
+func TestList(t *testing.T) {
+	v, err := kubeschema.New("")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"items[1].data.answer: expected string, got integer",
+		"items[2]: apiVersion and kind are required",
+	}, validate(t, v, `
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  data:
+    foo: bar
+- apiVersion: v1
+  kind: ConfigMap
+  data:
+    answer: 42
+- metadata: {}
+`))
+}
+
+func TestUserSchemas(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "crds.yaml"), []byte(crd), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(dir, "configmap-v1.json"), []byte(`{"properties": {"data": {"required": ["mandatory"]}}}`), 0644)
+	assert.NoError(t, err)
+
+	v, err := kubeschema.New(dir)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"spec.image: required field is missing",
+		"spec.replicas.min: expected integer, got string",
+	}, validate(t, v, `
+apiVersion: nais.io/v1alpha1
+kind: Application
+spec:
+  replicas:
+    min: two
+`))
+
+	assert.Equal(t, []string{"data.mandatory: required field is missing"}, validate(t, v, `
+apiVersion: v1
+kind: ConfigMap
+data: {}
+`))
+
+	_, err = v.Validate(decode(t, "apiVersion: example.com/v1\nkind: Unknown\n"))
+	assert.True(t, errors.Is(err, kubeschema.ErrNoSchema))
+	assert.EqualError(t, err, "no schema found for example.com/v1 Unknown")
+}
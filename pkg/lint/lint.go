@@ -0,0 +1,573 @@
+// Package lint finds variables that templates read but that are not defined, and variables that no template reads,
+// without rendering the templates.
+//
+// Templates are analyzed statically, by walking their parse trees from the root variables. The dot is followed
+// through 'with' and 'range' actions, template variables, and templates invoked with 'template' or 'include'.
+// References made where the dot can not be followed, such as the result of a function call, can not be checked;
+// their field names are remembered instead, so that variables with those names are not reported as unused.
+package lint
+
+import (
+	"fmt"
+	"github.com/nais/naisplater/pkg/templatetools"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Any matches every item of a list or every value of a map in a path, as iterated by 'range'.
+const Any = "*"
+
+// Path is a path to a variable, such as [app image]. The root variables have an empty path.
+type Path []string
+
+func (p Path) String() string {
+	return "." + strings.Join(p, ".")
+}
+
+func (p Path) append(elements ...string) Path {
+	return append(append(Path{}, p...), elements...)
+}
+
+// Reference is a variable read by a template, at a line in a template file.
+// Optional references, such as the result of 'index', may be missing without failing the template.
+type Reference struct {
+	Path     Path
+	Template string
+	Line     int
+	Optional bool
+	guards   []guard
+}
+
+func (r Reference) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.Template, r.Line, r.Path)
+}
+
+// guard is the condition of an 'if' or 'with' action around a reference.
+type guard struct {
+	path   Path
+	truthy bool
+}
+
+// Usage holds the references of a set of templates.
+type Usage struct {
+	References []Reference
+	fields     map[string]bool
+	seen       map[string]bool
+}
+
+// NewUsage returns an empty usage.
+func NewUsage() *Usage {
+	return &Usage{
+		References: make([]Reference, 0),
+		fields:     make(map[string]bool),
+		seen:       make(map[string]bool),
+	}
+}
+
+// Analyze adds the references of a template, executed with the root variables as dot,
+// and of all templates it invokes.
+func (u *Usage) Analyze(tpl *template.Template) {
+	if tpl.Tree == nil || tpl.Tree.Root == nil {
+		return
+	}
+	a := &analyzer{
+		usage:  u,
+		tpl:    tpl,
+		active: make(map[string]bool),
+		done:   make(map[string]bool),
+	}
+	root := value{path: Path{}, known: true}
+	a.walk(tpl.Tree.Root, &scope{
+		tree: tpl.Tree,
+		dot:  root,
+		vars: map[string]value{"$": root},
+	})
+}
+
+// Use records that a variable is read by something other than a template, such as a reference from another
+// variable. Such variables are never unused, and never reported as undefined.
+func (u *Usage) Use(path Path) {
+	u.add(Reference{Path: path, Optional: true})
+}
+
+func (u *Usage) add(r Reference) {
+	key := fmt.Sprintf("%s\x00%s\x00%d\x00%t\x00%v", r.Path, r.Template, r.Line, r.Optional, r.guards)
+	if u.seen[key] {
+		return
+	}
+	u.seen[key] = true
+	u.References = append(u.References, r)
+}
+
+// value is the result of an expression. Known values are variables with a path.
+type value struct {
+	path  Path
+	known bool
+}
+
+// scope is the state of the analysis at a node: the dot, the template variables, and the enclosing conditions.
+type scope struct {
+	tree   *parse.Tree
+	dot    value
+	vars   map[string]value
+	guards []guard
+}
+
+// child returns a scope for a nested block, where variables declared in the block are discarded at its end.
+func (s *scope) child(dot value, guards ...guard) *scope {
+	vars := make(map[string]value, len(s.vars))
+	for k, v := range s.vars {
+		vars[k] = v
+	}
+	return &scope{
+		tree:   s.tree,
+		dot:    dot,
+		vars:   vars,
+		guards: append(append([]guard{}, s.guards...), guards...),
+	}
+}
+
+type analyzer struct {
+	usage  *Usage
+	tpl    *template.Template
+	active map[string]bool
+	done   map[string]bool
+}
+
+func (a *analyzer) walk(node parse.Node, s *scope) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			a.walk(child, s)
+		}
+	case *parse.ActionNode:
+		a.declare(n.Pipe, s, a.pipe(n.Pipe, s))
+	case *parse.IfNode:
+		g, ok := a.condition(n.Pipe, s)
+		if !ok {
+			a.walk(n.List, s.child(s.dot))
+			a.walk(n.ElseList, s.child(s.dot))
+			return
+		}
+		a.walk(n.List, s.child(s.dot, g))
+		a.walk(n.ElseList, s.child(s.dot, guard{path: g.path, truthy: !g.truthy}))
+	case *parse.WithNode:
+		v := a.pipe(n.Pipe, s)
+		if !v.known {
+			a.walk(n.List, s.child(v))
+			a.walk(n.ElseList, s.child(s.dot))
+			return
+		}
+		inner := s.child(v, guard{path: v.path, truthy: true})
+		a.declare(n.Pipe, inner, v)
+		a.walk(n.List, inner)
+		a.walk(n.ElseList, s.child(s.dot, guard{path: v.path, truthy: false}))
+	case *parse.RangeNode:
+		v := a.pipe(n.Pipe, s)
+		item := value{}
+		if v.known {
+			item = value{path: v.path.append(Any), known: true}
+		}
+		inner := s.child(item)
+		switch len(n.Pipe.Decl) {
+		case 1:
+			inner.vars[n.Pipe.Decl[0].Ident[0]] = item
+		case 2:
+			inner.vars[n.Pipe.Decl[0].Ident[0]] = value{}
+			inner.vars[n.Pipe.Decl[1].Ident[0]] = item
+		}
+		a.walk(n.List, inner)
+		a.walk(n.ElseList, s.child(s.dot))
+	case *parse.TemplateNode:
+		v := value{}
+		if n.Pipe != nil {
+			v = a.pipe(n.Pipe, s)
+		}
+		a.invoke(n.Name, v, s)
+	}
+}
+
+// invoke analyzes a named template, executed with v as dot.
+func (a *analyzer) invoke(name string, v value, s *scope) {
+	tpl := a.tpl.Lookup(name)
+	if tpl == nil || tpl.Tree == nil || tpl.Tree.Root == nil {
+		return
+	}
+
+	key := fmt.Sprintf("%s\x00%t\x00%s\x00%v", name, v.known, v.path, s.guards)
+	if a.active[name] || a.done[key] {
+		return
+	}
+	a.active[name] = true
+	a.done[key] = true
+	defer delete(a.active, name)
+
+	inner := &scope{
+		tree:   tpl.Tree,
+		dot:    v,
+		vars:   map[string]value{"$": v},
+		guards: s.guards,
+	}
+	a.walk(tpl.Tree.Root, inner)
+}
+
+// condition returns the guard for an 'if' action on a single variable, such as '.enabled' or 'not .enabled'.
+func (a *analyzer) condition(pipe *parse.PipeNode, s *scope) (guard, bool) {
+	v := a.pipe(pipe, s)
+	if v.known {
+		return guard{path: v.path, truthy: true}, true
+	}
+
+	if len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 2 {
+		return guard{}, false
+	}
+	ident, ok := pipe.Cmds[0].Args[0].(*parse.IdentifierNode)
+	if !ok || ident.Ident != "not" {
+		return guard{}, false
+	}
+	v = a.resolve(pipe.Cmds[0].Args[1], s, false)
+	if !v.known {
+		return guard{}, false
+	}
+	return guard{path: v.path, truthy: false}, true
+}
+
+// pipe records the references of a pipeline, and returns its value.
+func (a *analyzer) pipe(pipe *parse.PipeNode, s *scope) value {
+	if pipe == nil {
+		return value{}
+	}
+
+	result := value{}
+	for i, cmd := range pipe.Cmds {
+		v := a.command(cmd, s)
+		if i == 0 && len(pipe.Cmds) == 1 {
+			result = v
+		}
+	}
+	return result
+}
+
+// declare assigns the value of a pipeline to the variable it declares or assigns, if any.
+func (a *analyzer) declare(pipe *parse.PipeNode, s *scope, v value) {
+	if len(pipe.Decl) == 1 {
+		s.vars[pipe.Decl[0].Ident[0]] = v
+	}
+}
+
+// command records the references of a command, and returns its value.
+func (a *analyzer) command(cmd *parse.CommandNode, s *scope) value {
+	if len(cmd.Args) == 0 {
+		return value{}
+	}
+
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		v := a.resolve(cmd.Args[0], s, len(cmd.Args) == 1)
+		for _, arg := range cmd.Args[1:] {
+			a.resolve(arg, s, true)
+		}
+		return v
+	}
+
+	switch ident.Ident {
+	case "include":
+		if len(cmd.Args) == 3 {
+			if name, ok := cmd.Args[1].(*parse.StringNode); ok {
+				a.invoke(name.Text, a.resolve(cmd.Args[2], s, false), s)
+				return value{}
+			}
+		}
+	case "index":
+		if len(cmd.Args) >= 2 {
+			v := a.resolve(cmd.Args[1], s, false)
+			for _, arg := range cmd.Args[2:] {
+				key, ok := arg.(*parse.StringNode)
+				if !ok || !v.known {
+					a.resolve(arg, s, true)
+					v = value{}
+					continue
+				}
+				v.path = v.path.append(key.Text)
+			}
+			if v.known {
+				a.reference(cmd.Args[1], v.path, true, s)
+			}
+			return v
+		}
+	}
+
+	for _, arg := range cmd.Args[1:] {
+		a.resolve(arg, s, true)
+	}
+	return value{}
+}
+
+// resolve records the references of an argument, and returns its value.
+// If used is true, the value is read as a whole, such as when passed to a function.
+func (a *analyzer) resolve(node parse.Node, s *scope, used bool) value {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		if used && s.dot.known {
+			a.reference(n, s.dot.path, true, s)
+		}
+		return s.dot
+	case *parse.FieldNode:
+		return a.fields(n, s.dot, n.Ident, s)
+	case *parse.VariableNode:
+		v, ok := s.vars[n.Ident[0]]
+		if !ok {
+			v = value{}
+		}
+		if len(n.Ident) == 1 {
+			if used && v.known {
+				a.reference(n, v.path, true, s)
+			}
+			return v
+		}
+		return a.fields(n, v, n.Ident[1:], s)
+	case *parse.ChainNode:
+		v := a.resolve(n.Node, s, false)
+		return a.fields(n, v, n.Field, s)
+	case *parse.PipeNode:
+		return a.pipe(n, s)
+	}
+	return value{}
+}
+
+// fields records a reference to fields of a value, such as '.app.image'.
+func (a *analyzer) fields(node parse.Node, v value, fields []string, s *scope) value {
+	if !v.known {
+		for _, field := range fields {
+			a.usage.fields[field] = true
+		}
+		return value{}
+	}
+	path := v.path.append(fields...)
+	a.reference(node, path, false, s)
+	return value{path: path, known: true}
+}
+
+func (a *analyzer) reference(node parse.Node, path Path, optional bool, s *scope) {
+	location, _ := s.tree.ErrorContext(node)
+	name, line := splitLocation(location)
+	a.usage.add(Reference{
+		Path:     path,
+		Template: name,
+		Line:     line,
+		Optional: optional,
+		guards:   s.guards,
+	})
+}
+
+// splitLocation splits a location such as 'app.yaml:12:5' into the template name and line.
+func splitLocation(location string) (string, int) {
+	parts := strings.Split(location, ":")
+	if len(parts) < 3 {
+		return location, 0
+	}
+	line, _ := strconv.Atoi(parts[len(parts)-2])
+	return strings.Join(parts[:len(parts)-2], ":"), line
+}
+
+// Undefined returns the references that would fail when rendering with vars, ordered by template and line.
+// References inside 'if' and 'with' actions are only checked if their condition holds.
+func (u *Usage) Undefined(vars interface{}) []Reference {
+	result := make([]Reference, 0)
+	for _, r := range u.References {
+		if r.Optional || !r.applies(vars) || defined(vars, r.Path) {
+			continue
+		}
+		result = append(result, r)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Template != result[j].Template {
+			return result[i].Template < result[j].Template
+		}
+		return result[i].Line < result[j].Line
+	})
+	return result
+}
+
+// applies returns true if all conditions around a reference hold.
+// Conditions on items of lists and maps can not be evaluated, and are assumed to hold.
+func (r Reference) applies(vars interface{}) bool {
+	for _, g := range r.guards {
+		v, ok := lookup(vars, g.path)
+		if !ok {
+			return false
+		}
+		if v != nil && truthy(v.value) != g.truthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Unused returns the paths of all variables in vars that no reference reads. Maps are descended into,
+// while all other values, including lists, are variables. Variables with the same name as a field
+// that is read from an unknown value are considered used.
+func (u *Usage) Unused(vars interface{}) []Path {
+	result := make([]Path, 0)
+	for _, path := range leaves(vars, Path{}) {
+		if !u.used(path) {
+			result = append(result, path)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].String() < result[j].String()
+	})
+	return result
+}
+
+func (u *Usage) used(path Path) bool {
+	for _, element := range path {
+		if u.fields[element] {
+			return true
+		}
+	}
+	for _, r := range u.References {
+		if overlaps(r.Path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps returns true if one path is a prefix of the other, so that reading one reads the other.
+func overlaps(a, b Path) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] && a[i] != Any && b[i] != Any {
+			return false
+		}
+	}
+	return true
+}
+
+// leaves returns the paths of all values in a variable tree that are not maps.
+func leaves(vars interface{}, path Path) []Path {
+	m, ok := asMap(vars)
+	if !ok || (len(m) == 0 && len(path) > 0) {
+		return []Path{path}
+	}
+	result := make([]Path, 0)
+	for k, v := range m {
+		result = append(result, leaves(v, path.append(fmt.Sprint(k)))...)
+	}
+	return result
+}
+
+func asMap(v interface{}) (map[interface{}]interface{}, bool) {
+	switch m := v.(type) {
+	case templatetools.Variables:
+		return m, true
+	case map[interface{}]interface{}:
+		return m, true
+	case map[string]interface{}:
+		result := make(map[interface{}]interface{}, len(m))
+		for k, v := range m {
+			result[k] = v
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// found is a value found by lookup. A nil *found means that a path matches several values.
+type found struct {
+	value interface{}
+}
+
+// lookup returns the value at a path, or nil if the path contains Any.
+// It returns false if the path is not defined.
+func lookup(vars interface{}, path Path) (*found, bool) {
+	current := vars
+	for i, element := range path {
+		if element == Any {
+			return nil, defined(current, path[i:])
+		}
+		m, ok := asMap(current)
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[element]
+		if !ok {
+			return nil, false
+		}
+	}
+	return &found{value: current}, true
+}
+
+// defined returns true if a path can be read from vars. A path with Any is defined
+// if it can be read from every item, which includes paths into empty lists and maps.
+func defined(vars interface{}, path Path) bool {
+	if len(path) == 0 {
+		return true
+	}
+
+	if path[0] == Any {
+		switch v := vars.(type) {
+		case nil:
+			return true
+		case []interface{}:
+			for _, item := range v {
+				if !defined(item, path[1:]) {
+					return false
+				}
+			}
+			return true
+		}
+		m, ok := asMap(vars)
+		if !ok {
+			return false
+		}
+		for _, item := range m {
+			if !defined(item, path[1:]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	m, ok := asMap(vars)
+	if !ok {
+		return false
+	}
+	v, ok := m[path[0]]
+	if !ok {
+		return false
+	}
+	return defined(v, path[1:])
+}
+
+// truthy returns true if a value is true in 'if' and 'with' actions.
+func truthy(v interface{}) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return len(t) > 0
+	case int:
+		return t != 0
+	case int64:
+		return t != 0
+	case uint64:
+		return t != 0
+	case float64:
+		return t != 0
+	case []interface{}:
+		return len(t) > 0
+	}
+	if m, ok := asMap(v); ok {
+		return len(m) > 0
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package lint_test
+
+import (
+	"github.com/nais/naisplater/pkg/lint"
+	"github.com/nais/naisplater/pkg/templatefuncs"
+	"github.com/nais/naisplater/pkg/templatetools"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const helpers = `
+{{- define "labels" -}}
+team: {{ .team }}
+app: {{ .app.name }}
+{{- end -}}
+{{- define "container" -}}
+image: {{ .image }}:{{ $.tag }}
+{{- end -}}
+`
+
+const app = `
+metadata:
+  labels:
+{{ include "labels" . | indent 4 }}
+spec:
+{{- with .app }}
+  replicas: {{ .replicas }}
+  {{- range $i, $c := .containers }}
+  - {{ template "container" $c }}
+    port: {{ $c.port }}
+  {{- end }}
+{{- end }}
+{{- if .ingress.enabled }}
+  host: {{ .ingress.host }}
+{{- else }}
+  internal: {{ index .ingress "internal" }}
+{{- end }}
+{{- if not .debug }}
+  level: {{ .level }}
+{{- end }}
+  env: {{ toYaml .env }}
+  value: {{ (fromYaml .raw).nested }}
+`
+
+var vars = templatetools.Variables{
+	"team":  "aura",
+	"debug": true,
+	"env": map[interface{}]interface{}{
+		"A": "1",
+		"B": "2",
+	},
+	"raw": "nested: true",
+	"app": map[interface{}]interface{}{
+		"name":     "app",
+		"replicas": 2,
+		"containers": []interface{}{
+			map[interface{}]interface{}{"image": "nginx", "port": 80},
+			map[interface{}]interface{}{"image": "redis"},
+		},
+	},
+	"ingress": map[interface{}]interface{}{
+		"enabled": false,
+	},
+	"unused": map[interface{}]interface{}{
+		"a": 1,
+		"b": []interface{}{"x"},
+	},
+	"nested": "used through an unknown value",
+}
+
+func analyze(t *testing.T) *lint.Usage {
+	tpl := template.New("app.yaml").Funcs(templatefuncs.FuncMap())
+	tpl = tpl.Funcs(template.FuncMap{"include": templatefuncs.Include(tpl)})
+	_, err := tpl.New("_helpers.tpl").Parse(helpers)
+	assert.NoError(t, err)
+	_, err = tpl.Parse(app)
+	assert.NoError(t, err)
+
+	usage := lint.NewUsage()
+	usage.Analyze(tpl)
+	return usage
+}
+
+func TestUndefined(t *testing.T) {
+	usage := analyze(t)
+
+	undefined := make([]string, 0)
+	for _, r := range usage.Undefined(vars) {
+		undefined = append(undefined, r.String())
+	}
+
+	assert.Equal(t, []string{
+		"_helpers.tpl:7: .app.containers.*.tag",
+		"app.yaml:10: .app.containers.*.port",
+	}, undefined)
+}
+
+func TestUndefinedGuards(t *testing.T) {
+	usage := analyze(t)
+
+	v := templatetools.Copy(vars)
+	v["ingress"] = map[interface{}]interface{}{"enabled": true}
+	v["debug"] = false
+
+	undefined := make([]string, 0)
+	for _, r := range usage.Undefined(v) {
+		undefined = append(undefined, r.String())
+	}
+
+	assert.Contains(t, undefined, "app.yaml:14: .ingress.host")
+	assert.Contains(t, undefined, "app.yaml:19: .level")
+	assert.NotContains(t, undefined, "app.yaml:16: .ingress.internal")
+}
+
+func TestUnused(t *testing.T) {
+	usage := analyze(t)
+
+	unused := make([]string, 0)
+	for _, path := range usage.Unused(vars) {
+		unused = append(unused, path.String())
+	}
+
+	assert.Equal(t, []string{".unused.a", ".unused.b"}, unused)
+}
+
+func TestUse(t *testing.T) {
+	usage := analyze(t)
+	usage.Use(lint.Path{"unused", "a"})
+
+	unused := make([]string, 0)
+	for _, path := range usage.Unused(vars) {
+		unused = append(unused, path.String())
+	}
+
+	assert.Equal(t, []string{".unused.b"}, unused)
+
+	v := templatetools.Copy(vars)
+	delete(v, "unused")
+	for _, r := range usage.Undefined(v) {
+		assert.NotEqual(t, ".unused.a", r.Path.String())
+	}
+}
@@ -0,0 +1,77 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Error is an error at a position in the injected data.
+// Lines and columns start at 1, and columns count characters. The column is 0 if it is not known.
+type Error struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// nodeError records the position of an error within a document, without adding it to the message,
+// so that it can be wrapped with the path of the failing resource.
+type nodeError struct {
+	line   int
+	column int
+	err    error
+}
+
+func (e *nodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nodeError) Unwrap() error {
+	return e.err
+}
+
+func errorAt(line, column int, format string, args ...interface{}) error {
+	return &nodeError{line: line, column: column, err: fmt.Errorf(format, args...)}
+}
+
+var yamlErrorLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// documentError returns an error for the document with the given index, starting at a line of the stream.
+// Errors are positioned at the failing node, the line of a syntax error, or else the start of the document.
+func documentError(err error, index, line int) error {
+	result := &Error{Line: line, Err: err}
+
+	var node *nodeError
+	if errors.As(err, &node) {
+		result.Line = line + node.line - 1
+		result.Column = node.column
+	} else if match := yamlErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		result.Line = line + n - 1
+		result.Err = errors.New(match[2])
+	}
+
+	result.Err = fmt.Errorf("document %d: %w", index, result.Err)
+	return result
+}
+
+// position returns the line and column of a byte offset.
+func position(data []byte, offset int) (int, int) {
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	start := bytes.LastIndexByte(data[:offset], '\n') + 1
+	return line, utf8.RuneCount(data[start:offset]) + 1
+}
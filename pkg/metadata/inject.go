@@ -0,0 +1,516 @@
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Inject adds labels and annotations to every resource in a stream of YAML documents.
+//
+// Documents are edited as text, so that comments, key order, quoting and block scalars are preserved,
+// and the output only differs from the input by the added labels and annotations. Documents that can not
+// be edited that way, such as resources with flow style metadata or aliased labels, are re-encoded
+// from the node tree instead, which keeps scalar tags and representations but may change formatting.
+//
+// Items of List documents get labels and annotations as well, while the List itself is left untouched.
+// Resources of excluded kinds are skipped. Inject returns warnings about resources that have no metadata,
+// and about documents that changed in other ways than the added labels and annotations.
+func (m *Metadata) Inject(data []byte) ([]byte, []string, error) {
+	out := &bytes.Buffer{}
+	warnings := make([]string, 0)
+	index := 0
+	line := 1
+
+	for _, text := range splitDocuments(data) {
+		start := line
+		line += bytes.Count(text, []byte("\n"))
+
+		root := &yaml.Node{}
+		err := yaml.Unmarshal(text, root)
+		if err != nil {
+			return nil, nil, documentError(err, index+1, start)
+		}
+		if root.Kind != yaml.DocumentNode {
+			// only comments or whitespace
+			out.Write(text)
+			continue
+		}
+		index++
+
+		resource := root.Content[0]
+		if isNull(resource) || (resource.Kind == yaml.MappingNode && len(resource.Content) == 0) {
+			out.Write(text)
+			continue
+		}
+		if resource.Kind != yaml.MappingNode {
+			return nil, nil, &Error{Line: start + resource.Line - 1, Column: resource.Column, Err: fmt.Errorf("document %d is not a map", index)}
+		}
+
+		in := newInjector(m, text)
+		paths, err := in.injectDocument(resource)
+		if err != nil {
+			return nil, nil, documentError(err, index, start)
+		}
+		for _, path := range paths {
+			if path == "." {
+				warnings = append(warnings, fmt.Sprintf("document %d has no metadata; labels and annotations not added", index))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("document %d: %s has no metadata; labels and annotations not added", index, path))
+			}
+		}
+
+		if len(in.edits) == 0 && !in.reencode {
+			out.Write(text)
+			continue
+		}
+
+		document := &bytes.Buffer{}
+		err = in.write(document, root)
+		if err != nil {
+			return nil, nil, documentError(err, index, start)
+		}
+
+		path, err := m.verify(text, document.Bytes())
+		if err != nil {
+			return nil, nil, documentError(err, index, start)
+		}
+		if len(path) > 0 {
+			warnings = append(warnings, fmt.Sprintf("document %d: value of %s changed when adding labels and annotations", index, path))
+		}
+
+		out.Write(document.Bytes())
+	}
+
+	return out.Bytes(), warnings, nil
+}
+
+// splitDocuments splits a YAML stream into documents without losing any text.
+// A line starting with the '---' marker begins a new document, and a line with the '...' marker ends one.
+func splitDocuments(data []byte) [][]byte {
+	documents := make([][]byte, 0)
+	start := 0
+
+	for offset := 0; offset < len(data); {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += offset + 1
+		}
+		line := data[offset:end]
+		switch {
+		case isMarker(line, "---") && offset > start:
+			documents = append(documents, data[start:offset])
+			start = offset
+		case isMarker(line, "..."):
+			documents = append(documents, data[start:end])
+			start = end
+		}
+		offset = end
+	}
+
+	if start < len(data) {
+		documents = append(documents, data[start:])
+	}
+
+	return documents
+}
+
+func isMarker(line []byte, marker string) bool {
+	if !bytes.HasPrefix(line, []byte(marker)) {
+		return false
+	}
+	return len(line) == len(marker) || strings.IndexByte(" \t\r\n", line[len(marker)]) >= 0
+}
+
+// edit replaces the text between start and end.
+type edit struct {
+	start int
+	end   int
+	text  string
+}
+
+// injector adds labels and annotations to a single document. The node tree is always updated,
+// and text edits are recorded as long as the document can be edited in place.
+type injector struct {
+	meta     *Metadata
+	text     []byte
+	lines    []int
+	edits    []edit
+	reencode bool
+}
+
+func newInjector(meta *Metadata, text []byte) *injector {
+	lines := []int{0}
+	for i, c := range text {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return &injector{
+		meta:  meta,
+		text:  text,
+		lines: lines,
+	}
+}
+
+// write writes the edited document, or re-encodes the node tree if it could not be edited in place.
+func (in *injector) write(out *bytes.Buffer, root *yaml.Node) error {
+	if in.reencode {
+		if isMarker(in.text, "---") {
+			out.WriteString("---\n")
+		}
+		encoder := yaml.NewEncoder(out)
+		encoder.SetIndent(2)
+		err := encoder.Encode(root)
+		if err != nil {
+			return err
+		}
+		return encoder.Close()
+	}
+
+	sort.SliceStable(in.edits, func(i, j int) bool {
+		return in.edits[i].start < in.edits[j].start
+	})
+
+	offset := 0
+	for _, e := range in.edits {
+		out.Write(in.text[offset:e.start])
+		out.WriteString(e.text)
+		offset = e.end
+	}
+	out.Write(in.text[offset:])
+
+	return nil
+}
+
+// injectDocument injects into a resource, or into all items of documents such as 'kind: List' or 'kind: ConfigMapList'.
+// It returns the paths of resources without metadata, where "." is the document itself.
+func (in *injector) injectDocument(resource *yaml.Node) ([]string, error) {
+	missing := make([]string, 0)
+
+	items := lookup(resource, "items")
+	if !strings.HasSuffix(scalar(resource, "kind"), "List") || items < 0 || resource.Content[items].Kind != yaml.SequenceNode {
+		found, err := in.inject(resource)
+		if !found {
+			missing = append(missing, ".")
+		}
+		return missing, err
+	}
+
+	for i, item := range resource.Content[items].Content {
+		path := fmt.Sprintf("items[%d]", i)
+		if item.Kind != yaml.MappingNode {
+			return missing, errorAt(item.Line, item.Column, "%s is not a map", path)
+		}
+		found, err := in.inject(item)
+		if err != nil {
+			return missing, fmt.Errorf("%s: %w", path, err)
+		}
+		if !found {
+			missing = append(missing, path)
+		}
+	}
+
+	return missing, nil
+}
+
+// inject adds labels and annotations to a single resource,
+// and returns false if the resource has no metadata.
+func (in *injector) inject(resource *yaml.Node) (bool, error) {
+	if in.meta.Excluded(scalar(resource, "kind")) {
+		return true, nil
+	}
+
+	i := lookup(resource, "metadata")
+	if i < 0 || isNull(resource.Content[i]) {
+		return false, nil
+	}
+	if resource.Content[i].Kind == yaml.AliasNode {
+		in.unalias(resource, i)
+	}
+	key, metadata := resource.Content[i-1], resource.Content[i]
+	if metadata.Kind != yaml.MappingNode {
+		return true, errorAt(metadata.Line, metadata.Column, "metadata is not a map")
+	}
+
+	err := in.injectMap(key, metadata, "labels", in.meta.Labels)
+	if err != nil {
+		return true, err
+	}
+
+	return true, in.injectMap(key, metadata, "annotations", in.meta.Annotations)
+}
+
+func (in *injector) injectMap(metadataKey, metadata *yaml.Node, field string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	i := lookup(metadata, field)
+	if i < 0 {
+		err := in.insertMap(metadataKey, metadata, field, keys, values)
+		if err != nil {
+			return err
+		}
+		target := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		metadata.Content = append(metadata.Content, stringNode(field), target)
+		for _, key := range keys {
+			target.Content = append(target.Content, stringNode(key), stringNode(values[key]))
+		}
+		return nil
+	}
+
+	if metadata.Content[i].Kind == yaml.AliasNode {
+		in.unalias(metadata, i)
+	}
+	if isNull(metadata.Content[i]) {
+		metadata.Content[i] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		in.reencode = true
+	}
+	target := metadata.Content[i]
+	if target.Kind != yaml.MappingNode {
+		return errorAt(target.Line, target.Column, "metadata.%s is not a map", field)
+	}
+	if target.Style&yaml.FlowStyle != 0 || len(target.Content) == 0 {
+		in.reencode = true
+	}
+
+	added := make([]string, 0)
+	for _, key := range keys {
+		j := lookup(target, key)
+		if j < 0 {
+			added = append(added, key)
+			continue
+		}
+		existing := target.Content[j]
+		if existing.Kind == yaml.ScalarNode && existing.Tag == "!!str" && existing.Value == values[key] {
+			continue
+		}
+		err := in.replaceScalar(existing, values[key])
+		if err != nil {
+			return err
+		}
+		target.Content[j] = stringNode(values[key])
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+
+	err := in.insertEntries(target, added, values)
+	if err != nil {
+		return err
+	}
+	for _, key := range added {
+		target.Content = append(target.Content, stringNode(key), stringNode(values[key]))
+	}
+
+	return nil
+}
+
+// insertEntries inserts key/value pairs before the first entry of a block mapping.
+func (in *injector) insertEntries(mapping *yaml.Node, keys []string, values map[string]string) error {
+	if in.reencode {
+		return nil
+	}
+
+	first := mapping.Content[0]
+	indent := strings.Repeat(" ", first.Column-1)
+
+	buf := &strings.Builder{}
+	for _, key := range keys {
+		entry, err := encodeEntry(key, values[key], indent)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(entry)
+		buf.WriteString("\n")
+		buf.WriteString(indent)
+	}
+
+	in.insert(in.offset(first), buf.String())
+
+	return nil
+}
+
+// insertMap inserts a new field with a nested block mapping before the first entry of metadata.
+// The nested mapping is indented like the metadata is indented relative to its key.
+func (in *injector) insertMap(metadataKey, metadata *yaml.Node, field string, keys []string, values map[string]string) error {
+	if metadata.Style&yaml.FlowStyle != 0 || len(metadata.Content) == 0 {
+		in.reencode = true
+	}
+	if in.reencode {
+		return nil
+	}
+
+	first := metadata.Content[0]
+	step := first.Column - metadataKey.Column
+	if step <= 0 {
+		step = 2
+	}
+	indent := strings.Repeat(" ", first.Column-1)
+	childIndent := indent + strings.Repeat(" ", step)
+
+	buf := &strings.Builder{}
+	buf.WriteString(field)
+	buf.WriteString(":\n")
+	for _, key := range keys {
+		entry, err := encodeEntry(key, values[key], childIndent)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(childIndent)
+		buf.WriteString(entry)
+		buf.WriteString("\n")
+	}
+	buf.WriteString(indent)
+
+	in.insert(in.offset(first), buf.String())
+
+	return nil
+}
+
+// replaceScalar replaces the text of a single scalar value.
+func (in *injector) replaceScalar(node *yaml.Node, value string) error {
+	if in.reencode {
+		return nil
+	}
+
+	start := in.offset(node)
+	end, ok := in.scalarEnd(node, start)
+	if !ok {
+		in.reencode = true
+		return nil
+	}
+
+	text, err := yaml.Marshal(stringNode(value))
+	if err != nil {
+		return err
+	}
+	replacement := strings.TrimSuffix(string(text), "\n")
+	if strings.Contains(replacement, "\n") {
+		in.reencode = true
+		return nil
+	}
+
+	in.edits = append(in.edits, edit{start: start, end: end, text: replacement})
+
+	return nil
+}
+
+// scalarEnd returns the offset after the text of a scalar starting at the given offset.
+// Block scalars, multi-line plain scalars and scalars with tags or anchors are not supported.
+func (in *injector) scalarEnd(node *yaml.Node, start int) (int, bool) {
+	if node.Kind != yaml.ScalarNode || len(node.Anchor) > 0 || node.Style&yaml.TaggedStyle != 0 {
+		return 0, false
+	}
+
+	text := in.text
+
+	switch node.Style {
+	case yaml.DoubleQuotedStyle:
+		for i := start + 1; i < len(text); i++ {
+			switch text[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, true
+			}
+		}
+	case yaml.SingleQuotedStyle:
+		for i := start + 1; i < len(text); i++ {
+			if text[i] != '\'' {
+				continue
+			}
+			if i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	case 0:
+		end := start + len(node.Value)
+		if len(node.Value) > 0 && bytes.HasPrefix(text[start:], []byte(node.Value)) {
+			if end == len(text) || strings.IndexByte(" \t\r\n", text[end]) >= 0 {
+				return end, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func (in *injector) insert(offset int, text string) {
+	in.edits = append(in.edits, edit{start: offset, end: offset, text: text})
+}
+
+// offset returns the byte offset of a node. Columns are counted in characters.
+func (in *injector) offset(node *yaml.Node) int {
+	offset := in.lines[node.Line-1]
+	for column := 1; column < node.Column && offset < len(in.text); column++ {
+		_, size := utf8.DecodeRune(in.text[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// unalias replaces an alias with a copy of the aliased node, so that it can be changed
+// without affecting the anchor. Aliases are expanded when the document is re-encoded.
+func (in *injector) unalias(parent *yaml.Node, i int) {
+	node := *parent.Content[i].Alias
+	node.Anchor = ""
+	node.Content = append([]*yaml.Node{}, node.Content...)
+	parent.Content[i] = &node
+	in.reencode = true
+}
+
+// encodeEntry encodes a single key/value pair, indenting continuation lines of multi-line values.
+func encodeEntry(key, value, indent string) (string, error) {
+	node := &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Content: []*yaml.Node{stringNode(key), stringNode(value)},
+	}
+	text, err := yaml.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(strings.TrimSuffix(string(text), "\n"), "\n", "\n"+indent), nil
+}
+
+func stringNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// lookup returns the index of the value for a key in a mapping node, or -1 if the key is not found.
+func lookup(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Kind == yaml.ScalarNode && mapping.Content[i].Value == key {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// scalar returns the value for a key in a mapping node, or an empty string if it is not a scalar.
+func scalar(mapping *yaml.Node, key string) string {
+	i := lookup(mapping, key)
+	if i < 0 || mapping.Content[i].Kind != yaml.ScalarNode {
+		return ""
+	}
+	return mapping.Content[i].Value
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
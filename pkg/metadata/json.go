@@ -0,0 +1,382 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// InjectJSON adds labels and annotations to a Kubernetes resource in JSON format, or to the items of a List.
+// Objects without a kind are not resources, and are left untouched. Like Inject, the document is edited as text,
+// so that formatting and key order are preserved. It returns warnings about resources that have no metadata.
+func (m *Metadata) InjectJSON(data []byte) ([]byte, []string, error) {
+	err := ValidateJSON(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &jsonParser{data: data}
+	root := p.parse()
+
+	in := &jsonInjector{meta: m, data: data}
+	warnings := make([]string, 0)
+
+	if root.kind != '{' {
+		return data, warnings, nil
+	}
+
+	items := root.member("items")
+	if !strings.HasSuffix(root.member("kind").stringValue(), "List") || items == nil || items.kind != '[' {
+		found, err := in.inject(root)
+		if err != nil {
+			return nil, nil, jsonError(err)
+		}
+		if !found {
+			warnings = append(warnings, "resource has no metadata; labels and annotations not added")
+		}
+	} else {
+		for i, item := range items.items {
+			path := fmt.Sprintf("items[%d]", i)
+			if item.kind != '{' {
+				return nil, nil, jsonError(in.errorAt(item, "%s is not an object", path))
+			}
+			found, err := in.inject(item)
+			if err != nil {
+				return nil, nil, jsonError(fmt.Errorf("%s: %w", path, err))
+			}
+			if !found {
+				warnings = append(warnings, fmt.Sprintf("%s has no metadata; labels and annotations not added", path))
+			}
+		}
+	}
+
+	sort.SliceStable(in.edits, func(i, j int) bool {
+		return in.edits[i].start < in.edits[j].start
+	})
+
+	out := &bytes.Buffer{}
+	offset := 0
+	for _, e := range in.edits {
+		out.Write(data[offset:e.start])
+		out.WriteString(e.text)
+		offset = e.end
+	}
+	out.Write(data[offset:])
+
+	return out.Bytes(), warnings, nil
+}
+
+// ValidateJSON returns an error with the line and column of the first syntax error, if any.
+func ValidateJSON(data []byte) error {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err == nil {
+		return nil
+	}
+
+	syntaxError, ok := err.(*json.SyntaxError)
+	if !ok {
+		return err
+	}
+
+	// The offset is just past the offending character.
+	offset := int(syntaxError.Offset) - 1
+	if offset > len(data) {
+		offset = len(data)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	line, column := position(data, offset)
+
+	return &Error{Line: line, Column: column, Err: err}
+}
+
+type jsonInjector struct {
+	meta  *Metadata
+	data  []byte
+	edits []edit
+}
+
+// inject adds labels and annotations to a resource, and returns false if the resource has no metadata.
+func (in *jsonInjector) inject(resource *jsonValue) (bool, error) {
+	kind := resource.member("kind")
+	if kind == nil || in.meta.Excluded(kind.stringValue()) {
+		return true, nil
+	}
+
+	metadata := resource.member("metadata")
+	if metadata == nil || metadata.kind == 'n' {
+		return false, nil
+	}
+	if metadata.kind != '{' {
+		return true, in.errorAt(metadata, "metadata is not an object")
+	}
+
+	for _, field := range []struct {
+		name   string
+		values map[string]string
+	}{{"labels", in.meta.Labels}, {"annotations", in.meta.Annotations}} {
+		err := in.injectObject(resource, metadata, field.name, field.values)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+func (in *jsonInjector) injectObject(resource, metadata *jsonValue, field string, values map[string]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	target := metadata.member(field)
+	if target == nil {
+		indent := in.indentStep(resource, metadata)
+		buf := &strings.Builder{}
+		separator := in.separator(metadata)
+		colon := in.colon(metadata)
+		comma := ","
+		if len(indent) == 0 && strings.HasSuffix(colon, " ") {
+			comma = ", "
+		}
+		buf.WriteString("{")
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteString(comma)
+			}
+			if len(indent) > 0 {
+				buf.WriteString(separator + indent)
+			}
+			buf.WriteString(quoteJSON(key) + colon + quoteJSON(values[key]))
+		}
+		if len(indent) > 0 {
+			buf.WriteString(separator)
+		}
+		buf.WriteString("}")
+		in.insertMembers(metadata, []string{quoteJSON(field) + in.colon(metadata) + buf.String()})
+		return nil
+	}
+
+	if target.kind != '{' {
+		return in.errorAt(target, "metadata.%s is not an object", field)
+	}
+
+	members := make([]string, 0)
+	for _, key := range keys {
+		existing := target.member(key)
+		if existing == nil {
+			members = append(members, quoteJSON(key)+in.colon(target)+quoteJSON(values[key]))
+			continue
+		}
+		if existing.kind == '"' && existing.stringValue() == values[key] {
+			continue
+		}
+		in.edits = append(in.edits, edit{start: existing.start, end: existing.end, text: quoteJSON(values[key])})
+	}
+	in.insertMembers(target, members)
+
+	return nil
+}
+
+func (in *jsonInjector) errorAt(value *jsonValue, format string, args ...interface{}) error {
+	line, column := position(in.data, value.start)
+	return errorAt(line, column, format, args...)
+}
+
+// jsonError adds the position of the failing value to an error.
+func jsonError(err error) error {
+	var node *nodeError
+	if !errors.As(err, &node) {
+		return err
+	}
+	return &Error{Line: node.line, Column: node.column, Err: err}
+}
+
+// insertMembers inserts members at the beginning of an object, using the same whitespace as the first member.
+func (in *jsonInjector) insertMembers(object *jsonValue, members []string) {
+	if len(members) == 0 {
+		return
+	}
+
+	if len(object.members) == 0 {
+		in.edits = append(in.edits, edit{start: object.start, end: object.end, text: "{" + strings.Join(members, ", ") + "}"})
+		return
+	}
+
+	separator := in.separator(object)
+	buf := &strings.Builder{}
+	for _, member := range members {
+		buf.WriteString(separator)
+		buf.WriteString(member)
+		buf.WriteString(",")
+	}
+	in.edits = append(in.edits, edit{start: object.start + 1, end: object.start + 1, text: buf.String()})
+}
+
+// separator returns the whitespace between the opening brace of an object and its first member.
+func (in *jsonInjector) separator(object *jsonValue) string {
+	if len(object.members) == 0 {
+		return ""
+	}
+	return string(in.data[object.start+1 : object.members[0].keyStart])
+}
+
+// colon returns the text between the first key and value of an object, such as ": ".
+func (in *jsonInjector) colon(object *jsonValue) string {
+	if len(object.members) == 0 {
+		return ": "
+	}
+	first := object.members[0]
+	return string(in.data[first.keyEnd:first.value.start])
+}
+
+// indentStep returns the additional indentation of an object nested in parent,
+// or an empty string if the object is not indented over multiple lines.
+func (in *jsonInjector) indentStep(parent, object *jsonValue) string {
+	separator := in.separator(object)
+	if !strings.Contains(separator, "\n") {
+		return ""
+	}
+	outer := in.separator(parent)
+	if strings.HasPrefix(separator, outer) && len(separator) > len(outer) {
+		return separator[len(outer):]
+	}
+	return "  "
+}
+
+func quoteJSON(s string) string {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// jsonValue is a JSON value with the offsets of its text.
+// The kind is the first character of the value: '{', '[', '"', 'n' for null, or any other for numbers and booleans.
+type jsonValue struct {
+	kind    byte
+	start   int
+	end     int
+	text    string
+	members []jsonMember
+	items   []*jsonValue
+}
+
+type jsonMember struct {
+	key      string
+	keyStart int
+	keyEnd   int
+	value    *jsonValue
+}
+
+func (v *jsonValue) member(key string) *jsonValue {
+	if v == nil {
+		return nil
+	}
+	for _, m := range v.members {
+		if m.key == key {
+			return m.value
+		}
+	}
+	return nil
+}
+
+// stringValue returns the value of a string, or an empty string for other values.
+func (v *jsonValue) stringValue() string {
+	if v == nil {
+		return ""
+	}
+	return v.text
+}
+
+// jsonParser parses JSON that is known to be valid, recording the offsets of all values.
+type jsonParser struct {
+	data   []byte
+	offset int
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.offset < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.offset]) >= 0 {
+		p.offset++
+	}
+}
+
+func (p *jsonParser) parse() *jsonValue {
+	p.skipSpace()
+	v := &jsonValue{kind: p.data[p.offset], start: p.offset}
+
+	switch v.kind {
+	case '{':
+		p.offset++
+		for {
+			p.skipSpace()
+			if p.data[p.offset] == '}' {
+				break
+			}
+			member := jsonMember{keyStart: p.offset}
+			member.key = p.parseString()
+			member.keyEnd = p.offset
+			p.skipSpace()
+			p.offset++ // ':'
+			member.value = p.parse()
+			v.members = append(v.members, member)
+			p.skipSpace()
+			if p.data[p.offset] == ',' {
+				p.offset++
+			}
+		}
+		p.offset++
+	case '[':
+		p.offset++
+		for {
+			p.skipSpace()
+			if p.data[p.offset] == ']' {
+				break
+			}
+			v.items = append(v.items, p.parse())
+			p.skipSpace()
+			if p.data[p.offset] == ',' {
+				p.offset++
+			}
+		}
+		p.offset++
+	case '"':
+		v.text = p.parseString()
+	default:
+		for p.offset < len(p.data) && strings.IndexByte(",}] \t\r\n", p.data[p.offset]) < 0 {
+			p.offset++
+		}
+	}
+
+	v.end = p.offset
+	return v
+}
+
+// parseString parses a string starting at the current offset, and returns its value.
+func (p *jsonParser) parseString() string {
+	start := p.offset
+	p.offset++
+	for p.data[p.offset] != '"' {
+		if p.data[p.offset] == '\\' {
+			p.offset++
+		}
+		p.offset++
+	}
+	p.offset++
+
+	var s string
+	_ = json.Unmarshal(p.data[start:p.offset], &s)
+	return s
+}
@@ -0,0 +1,105 @@
+// Package metadata injects labels and annotations into rendered Kubernetes resources.
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"text/template"
+)
+
+// Config describes which labels and annotations to inject into every resource.
+// Values are Go templates, executed with the cluster variables.
+type Config struct {
+	Labels       map[string]string `yaml:"labels"`
+	Annotations  map[string]string `yaml:"annotations"`
+	ExcludeKinds []string          `yaml:"excludeKinds"`
+}
+
+// DefaultConfig returns the labels injected by naisplater if no configuration is given.
+func DefaultConfig() *Config {
+	return &Config{
+		Labels: map[string]string{
+			"nais.io/created-by": "nais-yaml",
+			"nais.io/touched-at": "{{ touchedAt }}",
+		},
+	}
+}
+
+// ReadConfig reads a metadata configuration file.
+func ReadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	err = yaml.UnmarshalStrict(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Metadata holds the labels and annotations for one cluster, with all templates resolved.
+type Metadata struct {
+	Labels       map[string]string
+	Annotations  map[string]string
+	excludeKinds map[string]bool
+}
+
+// Resolve executes all label and annotation templates with the given data.
+func (cfg *Config) Resolve(data interface{}, funcs template.FuncMap) (*Metadata, error) {
+	var err error
+
+	m := &Metadata{
+		excludeKinds: make(map[string]bool),
+	}
+
+	m.Labels, err = resolve("label", cfg.Labels, data, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Annotations, err = resolve("annotation", cfg.Annotations, data, funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, kind := range cfg.ExcludeKinds {
+		m.excludeKinds[kind] = true
+	}
+
+	return m, nil
+}
+
+func resolve(description string, templates map[string]string, data interface{}, funcs template.FuncMap) (map[string]string, error) {
+	result := make(map[string]string, len(templates))
+
+	for key, text := range templates {
+		tpl, err := template.New(key).Funcs(funcs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s '%s': %w", description, key, err)
+		}
+		buf := &bytes.Buffer{}
+		err = tpl.Execute(buf, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s '%s': %w", description, key, err)
+		}
+		result[key] = buf.String()
+	}
+
+	return result, nil
+}
+
+// Empty returns true if there is nothing to inject.
+func (m *Metadata) Empty() bool {
+	return len(m.Labels) == 0 && len(m.Annotations) == 0
+}
+
+// Excluded returns true if resources of this kind should be left untouched.
+func (m *Metadata) Excluded(kind string) bool {
+	return m.excludeKinds[kind]
+}
@@ -0,0 +1,413 @@
+package metadata_test
+
+import (
+	"github.com/nais/naisplater/pkg/metadata"
+	"strings"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var vars = map[interface{}]interface{}{
+	"team":        "aura",
+	"clusterName": "dev-gcp",
+}
+
+func funcs() template.FuncMap {
+	return template.FuncMap{
+		"touchedAt": func() string {
+			return "20210816T143957"
+		},
+	}
+}
+
+func resolve(t *testing.T, cfg *metadata.Config) *metadata.Metadata {
+	meta, err := cfg.Resolve(vars, funcs())
+	assert.NoError(t, err)
+	return meta
+}
+
+var teamLabel = &metadata.Config{
+	Labels: map[string]string{
+		"team": "{{ .team }}",
+	},
+}
+
+var injectTests = []struct {
+	name   string
+	input  string
+	output string
+}{
+	{
+		name: "existing labels",
+		input: `# a comment
+kind: ConfigMap
+metadata:
+  name: foo
+  labels:
+    app: foo # why
+data:
+  zulu: "1"
+  alpha: |
+    multi
+    line
+`,
+		output: `# a comment
+kind: ConfigMap
+metadata:
+  name: foo
+  labels:
+    team: aura
+    app: foo # why
+data:
+  zulu: "1"
+  alpha: |
+    multi
+    line
+`,
+	},
+	{
+		name: "no labels",
+		input: `kind: ConfigMap
+metadata:
+    name: foo
+`,
+		output: `kind: ConfigMap
+metadata:
+    labels:
+        team: aura
+    name: foo
+`,
+	},
+	{
+		name: "existing label is replaced",
+		input: `kind: ConfigMap
+metadata:
+  labels:
+    team: 'other' # owner
+    app: foo
+`,
+		output: `kind: ConfigMap
+metadata:
+  labels:
+    team: aura # owner
+    app: foo
+`,
+	},
+	{
+		name: "flow style metadata is re-encoded",
+		input: `kind: ConfigMap
+metadata: {name: foo}
+`,
+		output: `kind: ConfigMap
+metadata: {name: foo, labels: {team: aura}}
+`,
+	},
+	{
+		name: "multiple documents",
+		input: `---
+kind: ConfigMap
+metadata:
+  name: a
+---
+# nothing here
+---
+kind: Secret
+metadata:
+  name: b
+`,
+		output: `---
+kind: ConfigMap
+metadata:
+  labels:
+    team: aura
+  name: a
+---
+# nothing here
+---
+kind: Secret
+metadata:
+  labels:
+    team: aura
+  name: b
+`,
+	},
+}
+
+func TestInject(t *testing.T) {
+	meta := resolve(t, teamLabel)
+	for _, test := range injectTests {
+		output, warnings, err := meta.Inject([]byte(test.input))
+		assert.NoError(t, err, test.name)
+		assert.Empty(t, warnings, test.name)
+		assert.Equal(t, test.output, string(output), test.name)
+	}
+}
+
+func TestReencodePreservesScalars(t *testing.T) {
+	meta := resolve(t, teamLabel)
+
+	input := `kind: ConfigMap
+metadata: {name: foo}
+data:
+  enabled: on
+  mode: 0755
+  big: 123456789012345678901234567890
+  created: 2001-12-14t21:59:43.10-05:00
+  tagged: !!str 0x10
+  custom: !secret ref
+  quoted: "yes"
+`
+	output, warnings, err := meta.Inject([]byte(input))
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, `kind: ConfigMap
+metadata: {name: foo, labels: {team: aura}}
+data:
+  enabled: on
+  mode: 0755
+  big: 123456789012345678901234567890
+  created: 2001-12-14t21:59:43.10-05:00
+  tagged: !!str 0x10
+  custom: !secret ref
+  quoted: "yes"
+`, string(output))
+}
+
+func TestInjectChangedValues(t *testing.T) {
+	meta := resolve(t, teamLabel)
+
+	// labels inherited through a merge key are replaced by the added labels
+	_, warnings, err := meta.Inject([]byte(`base: &base
+  labels:
+    app: foo
+kind: ConfigMap
+metadata:
+  <<: *base
+  name: foo
+`))
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+	assert.Contains(t, warnings[0], "document 1: value of ")
+	assert.Contains(t, warnings[0], " changed when adding labels and annotations")
+}
+
+func TestDefaultConfig(t *testing.T) {
+	meta := resolve(t, metadata.DefaultConfig())
+
+	output, _, err := meta.Inject([]byte("kind: ConfigMap\nmetadata:\n  name: foo\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, `kind: ConfigMap
+metadata:
+  labels:
+    nais.io/created-by: nais-yaml
+    nais.io/touched-at: 20210816T143957
+  name: foo
+`, string(output))
+}
+
+func TestTemplatedLabelsAndAnnotations(t *testing.T) {
+	meta := resolve(t, &metadata.Config{
+		Labels: map[string]string{
+			"team": "{{ .team }}",
+		},
+		Annotations: map[string]string{
+			"example.com/cluster": "{{ .clusterName }}",
+		},
+		ExcludeKinds: []string{"CustomResourceDefinition"},
+	})
+
+	output, _, err := meta.Inject([]byte("kind: Service\nmetadata:\n  name: foo\n---\nkind: CustomResourceDefinition\nmetadata:\n  name: bar\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, `kind: Service
+metadata:
+  labels:
+    team: aura
+  annotations:
+    example.com/cluster: dev-gcp
+  name: foo
+---
+kind: CustomResourceDefinition
+metadata:
+  name: bar
+`, string(output))
+}
+
+func TestResolveMissingVariable(t *testing.T) {
+	cfg := &metadata.Config{
+		Labels: map[string]string{
+			"owner": "{{ .owner }}",
+		},
+	}
+	_, err := cfg.Resolve(vars, funcs())
+	assert.EqualError(t, err, `label 'owner': template: owner:1:3: executing "owner" at <.owner>: map has no entry for key "owner"`)
+}
+
+func TestInjectInvalidLabels(t *testing.T) {
+	meta := resolve(t, metadata.DefaultConfig())
+
+	_, _, err := meta.Inject([]byte("kind: ConfigMap\nmetadata:\n  labels: [foo]\n"))
+	assert.EqualError(t, err, "line 3, column 11: document 1: metadata.labels is not a map")
+}
+
+func TestInjectErrorPositions(t *testing.T) {
+	meta := resolve(t, metadata.DefaultConfig())
+
+	for _, test := range []struct {
+		data   string
+		line   int
+		column int
+		err    string
+	}{
+		{
+			data:   "kind: ConfigMap\n---\nkind: List\nitems:\n- kind: Secret\n  metadata: [foo]\n",
+			line:   6,
+			column: 13,
+			err:    "document 2: items[0]: metadata is not a map",
+		},
+		{
+			data: "kind: ConfigMap\n---\n# comment\nkind: Secret\nmetadata:\n  name: foo\n  bar: baz: qux\n",
+			line: 7,
+			err:  "document 2: mapping values are not allowed in this context",
+		},
+		{
+			data:   "---\n- foo\n",
+			line:   2,
+			column: 1,
+			err:    "document 1 is not a map",
+		},
+		{
+			data:   "{\n  \"kind\": \"List\",\n  \"items\": [\n    {\"kind\": \"Secret\", \"metadata\": 1}\n  ]\n}",
+			line:   4,
+			column: 36,
+			err:    "items[0]: metadata is not an object",
+		},
+	} {
+		var err error
+		if strings.HasPrefix(test.data, "{") {
+			_, _, err = meta.InjectJSON([]byte(test.data))
+		} else {
+			_, _, err = meta.Inject([]byte(test.data))
+		}
+		e, ok := err.(*metadata.Error)
+		if !assert.True(t, ok, test.data) {
+			continue
+		}
+		assert.Equal(t, test.line, e.Line, test.data)
+		assert.Equal(t, test.column, e.Column, test.data)
+		assert.EqualError(t, e.Err, test.err, test.data)
+	}
+}
+
+func TestInjectList(t *testing.T) {
+	meta := resolve(t, &metadata.Config{
+		Labels: map[string]string{
+			"team": "{{ .team }}",
+		},
+		ExcludeKinds: []string{"Namespace"},
+	})
+
+	output, warnings, err := meta.Inject([]byte(`apiVersion: v1
+kind: List
+items:
+- kind: ConfigMap
+  metadata:
+    name: foo
+- kind: Namespace
+  metadata:
+    name: bar
+- kind: Secret
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"document 1: items[2] has no metadata; labels and annotations not added"}, warnings)
+	assert.Equal(t, `apiVersion: v1
+kind: List
+items:
+- kind: ConfigMap
+  metadata:
+    labels:
+      team: aura
+    name: foo
+- kind: Namespace
+  metadata:
+    name: bar
+- kind: Secret
+`, string(output))
+}
+
+func TestInjectMissingMetadata(t *testing.T) {
+	meta := resolve(t, metadata.DefaultConfig())
+
+	_, warnings, err := meta.Inject([]byte("# only a comment\n---\nkind: ConfigMap\ndata: {}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"document 1 has no metadata; labels and annotations not added"}, warnings)
+}
+
+func TestInjectJSON(t *testing.T) {
+	meta := resolve(t, &metadata.Config{
+		Labels: map[string]string{
+			"team": "{{ .team }}",
+			"app":  "<app>",
+		},
+	})
+
+	output, warnings, err := meta.InjectJSON([]byte(`{
+  "kind": "ConfigMap",
+  "metadata": {
+    "name": "foo",
+    "labels": {
+      "app": "old"
+    }
+  },
+  "data": {"b": "1", "a": "2"}
+}
+`))
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, `{
+  "kind": "ConfigMap",
+  "metadata": {
+    "name": "foo",
+    "labels": {
+      "team": "aura",
+      "app": "<app>"
+    }
+  },
+  "data": {"b": "1", "a": "2"}
+}
+`, string(output))
+
+	output, _, err = meta.InjectJSON([]byte(`{
+    "kind": "ConfigMap",
+    "metadata": {
+        "name": "foo"
+    }
+}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+    "kind": "ConfigMap",
+    "metadata": {
+        "labels": {
+            "app": "<app>",
+            "team": "aura"
+        },
+        "name": "foo"
+    }
+}`, string(output))
+
+	output, warnings, err = meta.InjectJSON([]byte(`{"kind":"List","items":[{"kind":"Secret","metadata":{}},{"kind":"Secret"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"items[1] has no metadata; labels and annotations not added"}, warnings)
+	assert.Equal(t, `{"kind":"List","items":[{"kind":"Secret","metadata":{"labels": {"app": "<app>", "team": "aura"}}},{"kind":"Secret"}]}`, string(output))
+
+	output, warnings, err = meta.InjectJSON([]byte(`{"not": "a resource"}`))
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+	assert.Equal(t, `{"not": "a resource"}`, string(output))
+}
+
+func TestValidateJSON(t *testing.T) {
+	err := metadata.ValidateJSON([]byte("{\n  \"a\": 1,\n  \"b\": }\n"))
+	assert.EqualError(t, err, "line 3, column 8: invalid character '}' looking for beginning of value")
+}
@@ -0,0 +1,125 @@
+package metadata
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// verify checks that a document means the same after labels and annotations were added,
+// except for the labels and annotations themselves. Documents are compared as read by yaml.v2,
+// like most Kubernetes tooling does, so that e.g. 'on' or '0755' are caught if they change type.
+// It returns the path of the first value that differs, or an empty string if there are no differences.
+func (m *Metadata) verify(original, result []byte) (string, error) {
+	var expected, actual interface{}
+
+	err := yaml.Unmarshal(original, &expected)
+	if err != nil {
+		return "", err
+	}
+	err = yaml.Unmarshal(result, &actual)
+	if err != nil {
+		return "", fmt.Errorf("invalid YAML after adding labels and annotations: %w", err)
+	}
+
+	document, ok := expected.(map[interface{}]interface{})
+	if !ok {
+		return "", fmt.Errorf("document is not a map")
+	}
+
+	items, ok := document["items"].([]interface{})
+	kind, _ := document["kind"].(string)
+	if ok && strings.HasSuffix(kind, "List") {
+		for _, item := range items {
+			if resource, ok := item.(map[interface{}]interface{}); ok {
+				m.injectValues(resource)
+			}
+		}
+	} else {
+		m.injectValues(document)
+	}
+
+	path, differs := difference(expected, actual, "")
+	if differs && len(path) == 0 {
+		path = "."
+	}
+
+	return path, nil
+}
+
+// injectValues adds labels and annotations to a decoded resource.
+func (m *Metadata) injectValues(resource map[interface{}]interface{}) {
+	kind, _ := resource["kind"].(string)
+	if m.Excluded(kind) {
+		return
+	}
+
+	metadata, ok := resource["metadata"].(map[interface{}]interface{})
+	if !ok {
+		return
+	}
+
+	for field, values := range map[string]map[string]string{"labels": m.Labels, "annotations": m.Annotations} {
+		if len(values) == 0 {
+			continue
+		}
+		target, ok := metadata[field].(map[interface{}]interface{})
+		if !ok {
+			target = make(map[interface{}]interface{})
+			metadata[field] = target
+		}
+		for key, value := range values {
+			target[key] = value
+		}
+	}
+}
+
+// difference returns the path of the first value that differs between a and b, and whether there is a difference.
+func difference(a, b interface{}, path string) (string, bool) {
+	amap, aok := a.(map[interface{}]interface{})
+	bmap, bok := b.(map[interface{}]interface{})
+	if aok && bok {
+		keys := make(map[string]interface{})
+		names := make([]string, 0, len(amap)+len(bmap))
+		for _, m := range []map[interface{}]interface{}{amap, bmap} {
+			for key := range m {
+				name := fmt.Sprint(key)
+				if _, seen := keys[name]; !seen {
+					names = append(names, name)
+				}
+				keys[name] = key
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := name
+			if len(path) > 0 {
+				child = path + "." + name
+			}
+			av, ainside := amap[keys[name]]
+			bv, binside := bmap[keys[name]]
+			if ainside != binside {
+				return child, true
+			}
+			if diff, differs := difference(av, bv, child); differs {
+				return diff, true
+			}
+		}
+		return "", false
+	}
+
+	alist, aok := a.([]interface{})
+	blist, bok := b.([]interface{})
+	if aok && bok && len(alist) == len(blist) {
+		for i := range alist {
+			if diff, differs := difference(alist[i], blist[i], fmt.Sprintf("%s[%d]", path, i)); differs {
+				return diff, true
+			}
+		}
+		return "", false
+	}
+
+	return path, !reflect.DeepEqual(a, b)
+}
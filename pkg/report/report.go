@@ -0,0 +1,226 @@
+// Package report describes the outcome of rendering or validating templates for a set of clusters,
+// in formats that can be read by CI systems and bots.
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Report formats.
+const (
+	JSON  = "json"
+	JUnit = "junit"
+)
+
+// Status of a report, cluster or template.
+const (
+	Passed = "passed"
+	Failed = "failed"
+)
+
+// Report holds the results of all clusters. Durations are in seconds.
+type Report struct {
+	Status   string    `json:"status"`
+	Duration float64   `json:"duration"`
+	Clusters []Cluster `json:"clusters"`
+}
+
+// Cluster holds the results of all templates rendered for a cluster. The error is set if the cluster failed,
+// for instance because its variables could not be read or some of its templates failed.
+type Cluster struct {
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Duration  float64    `json:"duration"`
+	Templates []Template `json:"templates"`
+}
+
+// Template holds the result of rendering and validating a single template.
+type Template struct {
+	Path     string   `json:"path"`
+	Status   string   `json:"status"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Duration float64  `json:"duration"`
+}
+
+// New returns an empty report.
+func New() *Report {
+	return &Report{
+		Status:   Passed,
+		Clusters: make([]Cluster, 0),
+	}
+}
+
+// NewCluster returns the result of a cluster, with a status given by err and the status of its templates.
+func NewCluster(name string, templates []Template, duration time.Duration, err error) Cluster {
+	cluster := Cluster{
+		Name:      name,
+		Status:    Passed,
+		Duration:  duration.Seconds(),
+		Templates: templates,
+	}
+	if cluster.Templates == nil {
+		cluster.Templates = make([]Template, 0)
+	}
+	if err != nil {
+		cluster.Status = Failed
+		cluster.Error = err.Error()
+	}
+	for _, template := range cluster.Templates {
+		if template.Status == Failed {
+			cluster.Status = Failed
+		}
+	}
+	return cluster
+}
+
+// NewTemplate returns the result of a template, which has failed if there are any errors.
+func NewTemplate(path string, errors, warnings []string, duration time.Duration) Template {
+	template := Template{
+		Path:     path,
+		Status:   Passed,
+		Errors:   errors,
+		Warnings: warnings,
+		Duration: duration.Seconds(),
+	}
+	if len(errors) > 0 {
+		template.Status = Failed
+	}
+	return template
+}
+
+// Add adds the result of a cluster. Clusters are kept sorted by name.
+func (r *Report) Add(cluster Cluster) {
+	r.Clusters = append(r.Clusters, cluster)
+	sort.SliceStable(r.Clusters, func(i, j int) bool {
+		return r.Clusters[i].Name < r.Clusters[j].Name
+	})
+	if cluster.Status == Failed {
+		r.Status = Failed
+	}
+}
+
+// Write writes the report in the given format.
+func (r *Report) Write(w io.Writer, format string) error {
+	switch format {
+	case JSON:
+		return r.WriteJSON(w)
+	case JUnit:
+		return r.WriteJUnit(w)
+	}
+	return fmt.Errorf("unknown report format '%s'", format)
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(r)
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML, with a test suite for each cluster and a test case for each template.
+// Warnings are written as the output of the test case. A cluster that failed before any template was rendered
+// is written as a single failing test case named after the cluster.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suites := junitSuites{
+		Name: "naisplater",
+		Time: seconds(r.Duration),
+	}
+
+	for _, cluster := range r.Clusters {
+		suite := junitSuite{
+			Name: cluster.Name,
+			Time: seconds(cluster.Duration),
+		}
+
+		for _, template := range cluster.Templates {
+			test := junitCase{
+				Name:      template.Path,
+				Classname: cluster.Name,
+				Time:      seconds(template.Duration),
+				SystemOut: strings.Join(template.Warnings, "\n"),
+			}
+			if len(template.Errors) > 0 {
+				test.Failure = &junitFailure{
+					Message: template.Errors[0],
+					Text:    strings.Join(template.Errors, "\n"),
+				}
+			}
+			suite.Cases = append(suite.Cases, test)
+		}
+
+		if len(cluster.Templates) == 0 && cluster.Status == Failed {
+			suite.Cases = append(suite.Cases, junitCase{
+				Name:      cluster.Name,
+				Classname: cluster.Name,
+				Time:      seconds(cluster.Duration),
+				Failure:   &junitFailure{Message: cluster.Error, Text: cluster.Error},
+			})
+		}
+
+		for _, test := range suite.Cases {
+			suite.Tests++
+			if test.Failure != nil {
+				suite.Failures++
+			}
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(suites)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(duration float64) string {
+	return fmt.Sprintf("%.3f", duration)
+}
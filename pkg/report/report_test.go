@@ -0,0 +1,104 @@
+package report_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/nais/naisplater/pkg/report"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func example() *report.Report {
+	r := report.New()
+	r.Add(report.NewCluster("prod", []report.Template{
+		report.NewTemplate("tpl/app.yaml", nil, []string{"document 1 has no metadata; labels and annotations not added"}, 1500*time.Millisecond),
+		report.NewTemplate("tpl/db.yaml", []string{"document 1 (Deployment/db): spec.replicas: expected integer, got string", "document 2: <invalid>"}, nil, 0),
+	}, 2*time.Second, errors.New("encountered 2 errors; see log")))
+	r.Add(report.NewCluster("dev", nil, time.Second, errors.New("variables/dev.yaml: no such file")))
+	r.Duration = 3
+	return r
+}
+
+func TestNewCluster(t *testing.T) {
+	cluster := report.NewCluster("dev", []report.Template{report.NewTemplate("a.yaml", nil, nil, 0)}, 0, nil)
+	assert.Equal(t, report.Passed, cluster.Status)
+	assert.Empty(t, cluster.Error)
+
+	cluster = report.NewCluster("dev", []report.Template{report.NewTemplate("a.yaml", []string{"broken"}, nil, 0)}, 0, nil)
+	assert.Equal(t, report.Failed, cluster.Status)
+}
+
+func TestWriteJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := example().Write(buf, report.JSON)
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+  "status": "failed",
+  "duration": 3,
+  "clusters": [
+    {
+      "name": "dev",
+      "status": "failed",
+      "error": "variables/dev.yaml: no such file",
+      "duration": 1,
+      "templates": []
+    },
+    {
+      "name": "prod",
+      "status": "failed",
+      "error": "encountered 2 errors; see log",
+      "duration": 2,
+      "templates": [
+        {
+          "path": "tpl/app.yaml",
+          "status": "passed",
+          "warnings": [
+            "document 1 has no metadata; labels and annotations not added"
+          ],
+          "duration": 1.5
+        },
+        {
+          "path": "tpl/db.yaml",
+          "status": "failed",
+          "errors": [
+            "document 1 (Deployment/db): spec.replicas: expected integer, got string",
+            "document 2: <invalid>"
+          ],
+          "duration": 0
+        }
+      ]
+    }
+  ]
+}
+`, buf.String())
+}
+
+func TestWriteJUnit(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := example().Write(buf, report.JUnit)
+	assert.NoError(t, err)
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="naisplater" tests="3" failures="2" time="3.000">
+  <testsuite name="dev" tests="1" failures="1" time="1.000">
+    <testcase name="dev" classname="dev" time="1.000">
+      <failure message="variables/dev.yaml: no such file">variables/dev.yaml: no such file</failure>
+    </testcase>
+  </testsuite>
+  <testsuite name="prod" tests="2" failures="1" time="2.000">
+    <testcase name="tpl/app.yaml" classname="prod" time="1.500">
+      <system-out>document 1 has no metadata; labels and annotations not added</system-out>
+    </testcase>
+    <testcase name="tpl/db.yaml" classname="prod" time="0.000">
+      <failure message="document 1 (Deployment/db): spec.replicas: expected integer, got string">document 1 (Deployment/db): spec.replicas: expected integer, got string&#xA;document 2: &lt;invalid&gt;</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`, buf.String())
+}
+
+func TestUnknownFormat(t *testing.T) {
+	err := example().Write(&bytes.Buffer{}, "tap")
+	assert.EqualError(t, err, "unknown report format 'tap'")
+}
@@ -0,0 +1,219 @@
+package templatefuncs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version, see https://semver.org.
+type Version struct {
+	Major      int64
+	Minor      int64
+	Patch      int64
+	Prerelease string
+	Metadata   string
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + v.Prerelease
+	}
+	if len(v.Metadata) > 0 {
+		s += "+" + v.Metadata
+	}
+	return s
+}
+
+// ParseVersion parses a semantic version. A leading 'v' is accepted,
+// as are versions with missing minor or patch components.
+func ParseVersion(s string) (Version, error) {
+	v := Version{}
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		v.Metadata = rest[i+1:]
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i >= 0 {
+		v.Prerelease = rest[i+1:]
+		rest = rest[:i]
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 || len(parts[0]) == 0 {
+		return v, fmt.Errorf("invalid semantic version '%s'", s)
+	}
+
+	numbers := make([]int64, 3)
+	for i, part := range parts {
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid semantic version '%s'", s)
+		}
+		numbers[i] = n
+	}
+	v.Major, v.Minor, v.Patch = numbers[0], numbers[1], numbers[2]
+
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater than other.
+// Build metadata is ignored, as per the specification.
+func (v Version) Compare(other Version) int {
+	for _, pair := range [][2]int64{{v.Major, other.Major}, {v.Minor, other.Minor}, {v.Patch, other.Patch}} {
+		if pair[0] < pair[1] {
+			return -1
+		}
+		if pair[0] > pair[1] {
+			return 1
+		}
+	}
+	return comparePrerelease(v.Prerelease, other.Prerelease)
+}
+
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case len(a) == 0:
+		return 1
+	case len(b) == 0:
+		return -1
+	}
+
+	aparts := strings.Split(a, ".")
+	bparts := strings.Split(b, ".")
+	for i := 0; i < len(aparts) && i < len(bparts); i++ {
+		an, aerr := strconv.ParseInt(aparts[i], 10, 64)
+		bn, berr := strconv.ParseInt(bparts[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aparts[i], bparts[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(aparts) < len(bparts):
+		return -1
+	case len(aparts) > len(bparts):
+		return 1
+	}
+	return 0
+}
+
+func semverParse(s string) (Version, error) {
+	return ParseVersion(s)
+}
+
+// semverCompare checks a version against a constraint such as ">= 1.2.3, < 2".
+// Comma-separated constraints must all match, and '||' separates alternatives.
+// Supported operators are =, !=, >, >=, <, <=, ~ (same minor, or same major if no minor is given, as in Sprig)
+// and ^ (same first non-zero component).
+func semverCompare(constraint, version string) (bool, error) {
+	v, err := ParseVersion(version)
+	if err != nil {
+		return false, err
+	}
+
+	for _, alternative := range strings.Split(constraint, "||") {
+		ok := true
+		for _, term := range strings.Split(alternative, ",") {
+			match, err := matchConstraint(strings.TrimSpace(term), v)
+			if err != nil {
+				return false, err
+			}
+			ok = ok && match
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchConstraint(term string, v Version) (bool, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", "!=", "=", ">", "<", "~", "^"} {
+		if strings.HasPrefix(term, candidate) {
+			op = candidate
+			break
+		}
+	}
+
+	text := strings.TrimSpace(term[len(op):])
+	target, err := ParseVersion(text)
+	if err != nil {
+		return false, fmt.Errorf("invalid constraint '%s': %w", term, err)
+	}
+
+	c := v.Compare(target)
+
+	switch op {
+	case "", "=":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case "~":
+		// '~1' is '>=1.0.0 <2.0.0', while '~1.2' and '~1.2.3' are '<1.3.0'
+		if !hasMinor(text) {
+			return c >= 0 && v.Major == target.Major, nil
+		}
+		return c >= 0 && v.Major == target.Major && v.Minor == target.Minor, nil
+	case "^":
+		// the first non-zero component is pinned: '^1.2.3' is '<2.0.0', '^0.2.3' is '<0.3.0' and '^0.0.3' is '<0.0.4',
+		// while missing components are not: '^0' is '<1.0.0' and '^0.0' is '<0.1.0'
+		switch {
+		case v.Major != target.Major || c < 0:
+			return false, nil
+		case target.Major > 0 || !hasMinor(text):
+			return true, nil
+		case target.Minor > 0 || !hasPatch(text):
+			return v.Minor == target.Minor, nil
+		}
+		return v.Minor == target.Minor && v.Patch == target.Patch, nil
+	}
+
+	return false, nil
+}
+
+// hasMinor returns true if a version has a minor component, such as '1.2' but not '1' or '1-beta'.
+func hasMinor(version string) bool {
+	return components(version) > 1
+}
+
+// hasPatch returns true if a version has a patch component, such as '1.2.3' but not '1.2'.
+func hasPatch(version string) bool {
+	return components(version) > 2
+}
+
+// components returns the number of numeric components of a version.
+func components(version string) int {
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	return strings.Count(version, ".") + 1
+}
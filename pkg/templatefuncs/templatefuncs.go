@@ -0,0 +1,522 @@
+// Package templatefuncs contains the functions available to naisplater templates.
+package templatefuncs
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// FuncMap returns all helper functions that can be used in templates.
+// Argument order follows the Sprig conventions, so that the last argument
+// can be supplied through a pipeline, e.g. `{{ .name | default "foo" | quote }}`.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		// Legacy functions
+		"Join":       strings.Join,
+		"FlattenMap": flattenMap,
+
+		// Strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      title,
+		"camelcase":  camelcase,
+		"snakecase":  snakecase,
+		"kebabcase":  kebabcase,
+		"trim":       strings.TrimSpace,
+		"trimAll":    trimAll,
+		"trimPrefix": trimPrefix,
+		"trimSuffix": trimSuffix,
+		"replace":    replace,
+		"contains":   contains,
+		"hasPrefix":  hasPrefix,
+		"hasSuffix":  hasSuffix,
+		"splitList":  splitList,
+		"join":       join,
+		"quote":      quote,
+		"squote":     squote,
+		"indent":     indent,
+		"nindent":    nindent,
+		"repeat":     repeat,
+
+		// Defaults and assertions
+		"default":  defaultValue,
+		"empty":    empty,
+		"coalesce": coalesce,
+		"required": required,
+		"fail":     fail,
+
+		// Encoding
+		"b64enc":    b64enc,
+		"b64dec":    b64dec,
+		"sha256sum": sha256sum,
+		"toYaml":    toYaml,
+		"fromYaml":  fromYaml,
+		"toJson":    toJson,
+		"fromJson":  fromJson,
+
+		// Lists and dictionaries
+		"list":      list,
+		"dict":      dict,
+		"keys":      keys,
+		"hasKey":    hasKey,
+		"get":       get,
+		"has":       has,
+		"first":     first,
+		"last":      last,
+		"uniq":      uniq,
+		"sortAlpha": sortAlpha,
+
+		// Versions
+		"semver":        semverParse,
+		"semverCompare": semverCompare,
+	}
+}
+
+// Include returns the `include` function for a template set. It executes a named template
+// and returns the output as a string, so that it can be used in a pipeline.
+// This is not possible with the built-in `template` action.
+func Include(tpl *template.Template) func(name string, data interface{}) (string, error) {
+	return func(name string, data interface{}) (string, error) {
+		buf := &strings.Builder{}
+		err := tpl.ExecuteTemplate(buf, name, data)
+		return buf.String(), err
+	}
+}
+
+func flattenMap(vars map[interface{}]interface{}) []string {
+	result := make([]string, 0, len(vars))
+	for _, value := range vars {
+		result = append(result, fmt.Sprintf("%s", value))
+	}
+	return result
+}
+
+func toString(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case []byte:
+		return string(typed)
+	case fmt.Stringer:
+		return typed.String()
+	default:
+		return fmt.Sprintf("%v", typed)
+	}
+}
+
+// words splits a string into words on case changes, whitespace, dashes and underscores.
+func words(s string) []string {
+	result := make([]string, 0)
+	current := make([]rune, 0)
+	runes := []rune(s)
+
+	flush := func() {
+		if len(current) > 0 {
+			result = append(result, string(current))
+			current = current[:0]
+		}
+	}
+
+	for i, r := range runes {
+		switch {
+		case r == '-' || r == '_' || r == '.' || unicode.IsSpace(r):
+			flush()
+			continue
+		case unicode.IsUpper(r) && len(current) > 0:
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				flush()
+			}
+		}
+		current = append(current, r)
+	}
+	flush()
+
+	return result
+}
+
+func title(s string) string {
+	runes := []rune(s)
+	start := true
+	for i, r := range runes {
+		if unicode.IsSpace(r) {
+			start = true
+			continue
+		}
+		if start {
+			runes[i] = unicode.ToUpper(r)
+		}
+		start = false
+	}
+	return string(runes)
+}
+
+func camelcase(s string) string {
+	parts := words(s)
+	for i, part := range parts {
+		part = strings.ToLower(part)
+		if i > 0 {
+			part = title(part)
+		}
+		parts[i] = part
+	}
+	return strings.Join(parts, "")
+}
+
+func snakecase(s string) string {
+	return strings.ToLower(strings.Join(words(s), "_"))
+}
+
+func kebabcase(s string) string {
+	return strings.ToLower(strings.Join(words(s), "-"))
+}
+
+func trimAll(cutset, s string) string {
+	return strings.Trim(s, cutset)
+}
+
+func trimPrefix(prefix, s string) string {
+	return strings.TrimPrefix(s, prefix)
+}
+
+func trimSuffix(suffix, s string) string {
+	return strings.TrimSuffix(s, suffix)
+}
+
+func replace(old, new, s string) string {
+	return strings.ReplaceAll(s, old, new)
+}
+
+func contains(substr, s string) bool {
+	return strings.Contains(s, substr)
+}
+
+func hasPrefix(prefix, s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+func hasSuffix(suffix, s string) bool {
+	return strings.HasSuffix(s, suffix)
+}
+
+func splitList(sep, s string) []string {
+	return strings.Split(s, sep)
+}
+
+func join(sep string, value interface{}) (string, error) {
+	items, err := toList(value)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = toString(item)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func quote(values ...interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%q", toString(value))
+	}
+	return strings.Join(parts, " ")
+}
+
+func squote(values ...interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = "'" + toString(value) + "'"
+	}
+	return strings.Join(parts, " ")
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func nindent(spaces int, s string) string {
+	return "\n" + indent(spaces, s)
+}
+
+func repeat(count int, s string) string {
+	return strings.Repeat(s, count)
+}
+
+// empty returns true if the value is nil or the zero value of its type,
+// or an empty collection.
+func empty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
+
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+func coalesce(values ...interface{}) interface{} {
+	for _, value := range values {
+		if !empty(value) {
+			return value
+		}
+	}
+	return nil
+}
+
+func required(message string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, errors.New(message)
+	}
+	if s, ok := value.(string); ok && len(s) == 0 {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
+
+func fail(message string) (string, error) {
+	return "", errors.New(message)
+}
+
+func b64enc(value interface{}) string {
+	return base64.StdEncoding.EncodeToString([]byte(toString(value)))
+}
+
+func b64dec(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+func sha256sum(value interface{}) string {
+	hash := sha256.Sum256([]byte(toString(value)))
+	return hex.EncodeToString(hash[:])
+}
+
+func toYaml(value interface{}) (string, error) {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func fromYaml(s string) (map[interface{}]interface{}, error) {
+	result := make(map[interface{}]interface{})
+	err := yaml.Unmarshal([]byte(s), &result)
+	return result, err
+}
+
+func toJson(value interface{}) (string, error) {
+	compatible, err := jsonCompatible(value)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(compatible)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func fromJson(s string) (interface{}, error) {
+	var result interface{}
+	err := json.Unmarshal([]byte(s), &result)
+	return result, err
+}
+
+// jsonCompatible converts YAML maps with interface keys into maps with string keys,
+// so that they can be serialized by encoding/json.
+func jsonCompatible(value interface{}) (interface{}, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map:
+		result := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			converted, err := jsonCompatible(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[toString(iter.Key().Interface())] = converted
+		}
+		return result, nil
+	case reflect.Slice, reflect.Array:
+		if _, ok := value.([]byte); ok {
+			return value, nil
+		}
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			converted, err := jsonCompatible(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
+
+func toList(value interface{}) ([]interface{}, error) {
+	if value == nil {
+		return []interface{}{}, nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			result[i] = v.Index(i).Interface()
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("expected list, got %T", value)
+	}
+}
+
+func list(values ...interface{}) []interface{} {
+	return values
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict: odd number of arguments")
+	}
+	result := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		result[toString(pairs[i])] = pairs[i+1]
+	}
+	return result, nil
+}
+
+// keys returns the keys of one or more maps, sorted alphabetically.
+func keys(maps ...interface{}) ([]string, error) {
+	result := make([]string, 0)
+	for _, m := range maps {
+		v := reflect.ValueOf(m)
+		if v.Kind() != reflect.Map {
+			return nil, fmt.Errorf("keys: expected map, got %T", m)
+		}
+		for _, key := range v.MapKeys() {
+			result = append(result, toString(key.Interface()))
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func mapValue(m interface{}, key string) (reflect.Value, bool, error) {
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Map {
+		return reflect.Value{}, false, fmt.Errorf("expected map, got %T", m)
+	}
+	for _, k := range v.MapKeys() {
+		if toString(k.Interface()) == key {
+			return v.MapIndex(k), true, nil
+		}
+	}
+	return reflect.Value{}, false, nil
+}
+
+func hasKey(m interface{}, key string) (bool, error) {
+	_, found, err := mapValue(m, key)
+	return found, err
+}
+
+func get(m interface{}, key string) (interface{}, error) {
+	value, found, err := mapValue(m, key)
+	if err != nil || !found {
+		return nil, err
+	}
+	return value.Interface(), nil
+}
+
+func has(needle interface{}, haystack interface{}) (bool, error) {
+	items, err := toList(haystack)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range items {
+		if reflect.DeepEqual(item, needle) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func first(value interface{}) (interface{}, error) {
+	items, err := toList(value)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+func last(value interface{}) (interface{}, error) {
+	items, err := toList(value)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[len(items)-1], nil
+}
+
+func uniq(value interface{}) ([]interface{}, error) {
+	items, err := toList(value)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]interface{}, 0, len(items))
+OUTER:
+	for _, item := range items {
+		for _, existing := range result {
+			if reflect.DeepEqual(item, existing) {
+				continue OUTER
+			}
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func sortAlpha(value interface{}) ([]string, error) {
+	items, err := toList(value)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(items))
+	for i, item := range items {
+		result[i] = toString(item)
+	}
+	sort.Strings(result)
+	return result, nil
+}
@@ -0,0 +1,130 @@
+package templatefuncs_test
+
+import (
+	"bytes"
+	"github.com/nais/naisplater/pkg/templatefuncs"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var vars = map[interface{}]interface{}{
+	"name":  "my-app",
+	"empty": "",
+	"list":  []interface{}{"b", "a", "b"},
+	"map": map[interface{}]interface{}{
+		"zulu":  1,
+		"alpha": "two",
+	},
+	"version": "v1.21.3",
+}
+
+var funcTests = []struct {
+	template string
+	output   string
+}{
+	{`{{ upper .name }}`, `MY-APP`},
+	{`{{ "Hello World" | lower }}`, `hello world`},
+	{`{{ "hello world" | title }}`, `Hello World`},
+	{`{{ camelcase .name }}`, `myApp`},
+	{`{{ "someHTTPServer" | snakecase }}`, `some_http_server`},
+	{`{{ "FooBar baz" | kebabcase }}`, `foo-bar-baz`},
+	{`{{ "  padded  " | trim }}`, `padded`},
+	{`{{ trimPrefix "my-" .name }}`, `app`},
+	{`{{ .name | trimSuffix "-app" }}`, `my`},
+	{`{{ .name | replace "-" "_" }}`, `my_app`},
+	{`{{ contains "app" .name }}`, `true`},
+	{`{{ join "," .list }}`, `b,a,b`},
+	{`{{ Join (splitList "." "a.b.c") "/" }}`, `a/b/c`},
+	{`{{ .name | quote }}`, `"my-app"`},
+	{`{{ .name | squote }}`, `'my-app'`},
+	{`{{ .empty | default "fallback" }}`, `fallback`},
+	{`{{ .name | default "fallback" }}`, `my-app`},
+	{`{{ coalesce .empty "" "third" }}`, `third`},
+	{`{{ required "name is required" .name }}`, `my-app`},
+	{`{{ "secret" | b64enc }}`, `c2VjcmV0`},
+	{`{{ "c2VjcmV0" | b64dec }}`, `secret`},
+	{`{{ "foo" | sha256sum }}`, `2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae`},
+	{`{{ toYaml .map }}`, "alpha: two\nzulu: 1"},
+	{`{{ toJson .map }}`, `{"alpha":"two","zulu":1}`},
+	{`{{ (fromYaml "foo: bar").foo }}`, `bar`},
+	{`{{ (fromJson "{\"foo\": \"bar\"}").foo }}`, `bar`},
+	{`{{ toYaml .map | indent 2 }}`, "  alpha: two\n  zulu: 1"},
+	{`key:{{ toYaml .map | nindent 2 }}`, "key:\n  alpha: two\n  zulu: 1"},
+	{`{{ list 1 2 3 | toJson }}`, `[1,2,3]`},
+	{`{{ (dict "a" 1 "b" "c").b }}`, `c`},
+	{`{{ keys .map }}`, `[alpha zulu]`},
+	{`{{ hasKey .map "alpha" }}`, `true`},
+	{`{{ get .map "alpha" }}`, `two`},
+	{`{{ has "a" .list }}`, `true`},
+	{`{{ first .list }}/{{ last .list }}`, `b/b`},
+	{`{{ uniq .list | toJson }}`, `["b","a"]`},
+	{`{{ sortAlpha .list }}`, `[a b b]`},
+	{`{{ (semver .version).Minor }}`, `21`},
+	{`{{ semverCompare ">=1.20, <2" .version }}`, `true`},
+	{`{{ semverCompare "~1.20.0" .version }}`, `false`},
+	{`{{ semverCompare "~1.21" .version }}`, `true`},
+	{`{{ semverCompare "~1" .version }}`, `true`},
+	{`{{ semverCompare "~1" "2.0.0" }}`, `false`},
+	{`{{ semverCompare "^1.0.0" .version }}`, `true`},
+	{`{{ semverCompare "^1.22" .version }}`, `false`},
+	{`{{ semverCompare "^0.2.3" "0.2.9" }}`, `true`},
+	{`{{ semverCompare "^0.2.3" "0.3.0" }}`, `false`},
+	{`{{ semverCompare "^0.2.3" "0.2.2" }}`, `false`},
+	{`{{ semverCompare "^0.0.3" "0.0.3" }}`, `true`},
+	{`{{ semverCompare "^0.0.3" "0.0.4" }}`, `false`},
+	{`{{ semverCompare "^0.0" "0.0.9" }}`, `true`},
+	{`{{ semverCompare "^0.0" "0.1.0" }}`, `false`},
+	{`{{ semverCompare "^0" "0.9.0" }}`, `true`},
+	{`{{ semverCompare "^0" "1.0.0" }}`, `false`},
+	{`{{ semverCompare "<1.0.0 || >=1.21.3" .version }}`, `true`},
+	{`{{ FlattenMap (fromYaml "a: b") }}`, `[b]`},
+}
+
+func render(t *testing.T, text string) (string, error) {
+	tpl, err := template.New("test").Funcs(templatefuncs.FuncMap()).Parse(text)
+	if err != nil {
+		t.Fatalf("parse %s: %s", text, err)
+	}
+	buf := &bytes.Buffer{}
+	err = tpl.Execute(buf, vars)
+	return buf.String(), err
+}
+
+func TestFuncMap(t *testing.T) {
+	for _, test := range funcTests {
+		output, err := render(t, test.template)
+		assert.NoError(t, err, test.template)
+		assert.Equal(t, test.output, output, test.template)
+	}
+}
+
+func TestRequired(t *testing.T) {
+	_, err := render(t, `{{ required "empty is required" .empty }}`)
+	assert.EqualError(t, err, `template: test:1:3: executing "test" at <required "empty is required" .empty>: error calling required: empty is required`)
+}
+
+func TestInclude(t *testing.T) {
+	tpl := template.New("test").Funcs(templatefuncs.FuncMap())
+	tpl = tpl.Funcs(template.FuncMap{"include": templatefuncs.Include(tpl)})
+	tpl, err := tpl.Parse(`{{ define "labels" }}app: {{ .name }}{{ end }}labels:{{ include "labels" . | nindent 2 }}`)
+	assert.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	err = tpl.Execute(buf, vars)
+	assert.NoError(t, err)
+	assert.Equal(t, "labels:\n  app: my-app", buf.String())
+}
+
+func TestSemverOrdering(t *testing.T) {
+	ordered := []string{"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1", "v2"}
+	for i := 0; i < len(ordered)-1; i++ {
+		a, err := templatefuncs.ParseVersion(ordered[i])
+		assert.NoError(t, err)
+		b, err := templatefuncs.ParseVersion(ordered[i+1])
+		assert.NoError(t, err)
+		assert.Equal(t, -1, a.Compare(b), "%s < %s", a, b)
+		assert.Equal(t, 1, b.Compare(a), "%s > %s", b, a)
+	}
+}
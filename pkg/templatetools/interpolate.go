@@ -0,0 +1,283 @@
+package templatetools
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// reference matches '${path.to.variable}' references, and '$${' escapes for a literal '${'.
+var reference = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+// interpolator resolves references between variables. Every variable is resolved once,
+// and the chain of variables being resolved is kept to detect cycles.
+type interpolator struct {
+	vars      Variables
+	resolved  map[string]bool
+	resolving []string
+}
+
+// Interpolate replaces references to other variables, written as '${path.to.variable}', in all string values.
+// A string that is a single reference gets the referenced value, which can also be a number, list or map,
+// while references within a longer string must be to strings, numbers or booleans. Use '$${' for a literal '${'.
+// Variables are interpolated in place, and should be merged and decrypted first.
+func Interpolate(vars Variables) error {
+	in := &interpolator{
+		vars:     vars,
+		resolved: make(map[string]bool),
+	}
+	for _, k := range sortedKeys(vars) {
+		_, err := in.resolvePath([]string{fmt.Sprint(k)}, "")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EscapeReferences escapes everything that looks like a reference in a string, so that Interpolate leaves it as it is.
+func EscapeReferences(value string) string {
+	return strings.Replace(value, "${", "$${", -1)
+}
+
+// EscapeVariables escapes the strings in a variable tree in place, so that Interpolate leaves them as they are.
+// It is used for values that are not written in plaintext variable files, such as decrypted secrets,
+// which can still be referenced by other variables.
+func EscapeVariables(vars Variables) {
+	for k, v := range vars {
+		vars[k] = escapeValue(v)
+	}
+}
+
+func escapeValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case string:
+		return EscapeReferences(typed)
+	case Variables:
+		EscapeVariables(typed)
+	case []interface{}:
+		for i := range typed {
+			typed[i] = escapeValue(typed[i])
+		}
+	}
+	return value
+}
+
+// References returns the paths of all variables that are referenced by a value.
+func References(value interface{}) [][]string {
+	result := make([][]string, 0)
+	switch typed := value.(type) {
+	case string:
+		for _, match := range reference.FindAllStringSubmatch(typed, -1) {
+			if match[0] == "$${" {
+				continue
+			}
+			path, err := referencePath(match[1])
+			if err == nil {
+				result = append(result, path)
+			}
+		}
+	case Variables:
+		for _, v := range typed {
+			result = append(result, References(v)...)
+		}
+	case map[string]interface{}:
+		for _, v := range typed {
+			result = append(result, References(v)...)
+		}
+	case []interface{}:
+		for _, v := range typed {
+			result = append(result, References(v)...)
+		}
+	}
+	return result
+}
+
+func referencePath(expression string) ([]string, error) {
+	path := strings.TrimPrefix(strings.TrimSpace(expression), ".")
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty reference '${%s}'", expression)
+	}
+	return strings.Split(path, "."), nil
+}
+
+// locate returns the map that holds the variable at a path, and its key in that map.
+func (in *interpolator) locate(path []string) (Variables, interface{}, bool) {
+	current := in.vars
+	for i, element := range path {
+		key, ok := mapKey(current, element)
+		if !ok {
+			return nil, nil, false
+		}
+		if i == len(path)-1 {
+			return current, key, true
+		}
+		current, ok = current[key].(Variables)
+		if !ok {
+			return nil, nil, false
+		}
+	}
+	return nil, nil, false
+}
+
+// sortedKeys returns the keys of a map in a stable order, so that errors do not depend on map iteration.
+func sortedKeys(vars Variables) []interface{} {
+	keys := make([]interface{}, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+	return keys
+}
+
+// mapKey finds the key of a map for a path element, as keys are not always strings.
+func mapKey(vars Variables, element string) (interface{}, bool) {
+	if _, ok := vars[element]; ok {
+		return element, true
+	}
+	for k := range vars {
+		if fmt.Sprint(k) == element {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// lookup resolves the variable at a path, referenced by the variable named from.
+func (in *interpolator) lookup(path []string, from string) (interface{}, error) {
+	// a prefix of the path can be a reference to a map itself
+	for i := 1; i < len(path); i++ {
+		parent, key, ok := in.locate(path[:i])
+		if !ok {
+			break
+		}
+		if _, ok := parent[key].(string); ok {
+			_, err := in.resolvePath(path[:i], from)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	_, _, ok := in.locate(path)
+	if !ok {
+		return nil, fmt.Errorf("%s: reference to undefined variable '%s'", from, strings.Join(path, "."))
+	}
+
+	return in.resolvePath(path, from)
+}
+
+func (in *interpolator) resolvePath(path []string, from string) (interface{}, error) {
+	name := strings.Join(path, ".")
+	for i, resolving := range in.resolving {
+		if resolving == name {
+			chain := append(append([]string{}, in.resolving[i:]...), name)
+			return nil, fmt.Errorf("%s: reference cycle: %s", from, strings.Join(chain, " -> "))
+		}
+	}
+
+	parent, key, _ := in.locate(path)
+	if in.resolved[name] {
+		return parent[key], nil
+	}
+
+	in.resolving = append(in.resolving, name)
+	value, err := in.resolveValue(path, parent[key], true)
+	in.resolving = in.resolving[:len(in.resolving)-1]
+	if err != nil {
+		return nil, err
+	}
+
+	parent[key] = value
+	in.resolved[name] = true
+
+	return value, nil
+}
+
+// resolveValue resolves the references in a value. The variables of addressable maps are resolved by path, so
+// that they can be referenced themselves, while maps in lists can only be resolved as part of their list.
+func (in *interpolator) resolveValue(path []string, value interface{}, addressable bool) (interface{}, error) {
+	switch typed := value.(type) {
+	case string:
+		return in.interpolate(strings.Join(path, "."), typed)
+	case Variables:
+		for _, k := range sortedKeys(typed) {
+			child := append(append([]string{}, path...), fmt.Sprint(k))
+			if addressable {
+				_, err := in.resolvePath(child, strings.Join(path, "."))
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			resolved, err := in.resolveValue(child, typed[k], false)
+			if err != nil {
+				return nil, err
+			}
+			typed[k] = resolved
+		}
+		return typed, nil
+	case []interface{}:
+		for i := range typed {
+			resolved, err := in.resolveValue(append(append([]string{}, path...), fmt.Sprint(i)), typed[i], false)
+			if err != nil {
+				return nil, err
+			}
+			typed[i] = resolved
+		}
+		return typed, nil
+	}
+	return value, nil
+}
+
+// interpolate replaces the references in a string value.
+func (in *interpolator) interpolate(name, value string) (interface{}, error) {
+	matches := reference.FindAllStringSubmatchIndex(value, -1)
+	if len(matches) == 0 {
+		return value, nil
+	}
+
+	// a single reference keeps the type of the referenced value
+	if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(value) && value != "$${" {
+		path, err := referencePath(value[matches[0][2]:matches[0][3]])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		resolved, err := in.lookup(path, name)
+		if err != nil {
+			return nil, err
+		}
+		return copyValue(resolved), nil
+	}
+
+	result := &strings.Builder{}
+	last := 0
+	for _, match := range matches {
+		result.WriteString(value[last:match[0]])
+		last = match[1]
+		if value[match[0]:match[1]] == "$${" {
+			result.WriteString("${")
+			continue
+		}
+
+		path, err := referencePath(value[match[2]:match[3]])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		resolved, err := in.lookup(path, name)
+		if err != nil {
+			return nil, err
+		}
+		switch resolved.(type) {
+		case Variables, []interface{}, nil:
+			return nil, fmt.Errorf("%s: '%s' can not be part of a string, as it is not a string, number or boolean", name, strings.Join(path, "."))
+		}
+		result.WriteString(fmt.Sprint(resolved))
+	}
+	result.WriteString(value[last:])
+
+	return result.String(), nil
+}
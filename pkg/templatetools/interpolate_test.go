@@ -0,0 +1,101 @@
+package templatetools_test
+
+import (
+	"github.com/nais/naisplater/pkg/templatetools"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func variables(t *testing.T, data string) templatetools.Variables {
+	vars := templatetools.Variables{}
+	err := yaml.Unmarshal([]byte(data), &vars)
+	assert.NoError(t, err)
+	return vars
+}
+
+func TestInterpolate(t *testing.T) {
+	vars := variables(t, `
+clusterName: prod-gcp
+domain: example.com
+host: app.${clusterName}.${ domain }
+ingress:
+  hosts:
+    - ${host}
+    - ${.ingress.internal}
+  internal: app.intern.${domain}
+port: 8080
+url: http://${host}:${port}/
+replicas: ${scaling.replicas}
+scaling:
+  replicas: 3
+image: ${defaults.image}
+defaults:
+  image:
+    repository: nginx
+    tag: "1.21"
+tag: ${image.tag}
+containers:
+  - image: ${image.repository}
+literal: $${domain}
+`)
+
+	err := templatetools.Interpolate(vars)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "app.prod-gcp.example.com", vars["host"])
+	assert.Equal(t, []interface{}{"app.prod-gcp.example.com", "app.intern.example.com"}, vars["ingress"].(templatetools.Variables)["hosts"])
+	assert.Equal(t, "http://app.prod-gcp.example.com:8080/", vars["url"])
+	assert.Equal(t, 3, vars["replicas"])
+	assert.Equal(t, templatetools.Variables{"repository": "nginx", "tag": "1.21"}, vars["image"])
+	assert.Equal(t, "1.21", vars["tag"])
+	assert.Equal(t, "nginx", vars["containers"].([]interface{})[0].(templatetools.Variables)["image"])
+	assert.Equal(t, "${domain}", vars["literal"])
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		err  string
+	}{
+		{"host: app.${domian}\n", "host: reference to undefined variable 'domian'"},
+		{"a: ${b}\nb: x${a}\n", "b: reference cycle: a -> b -> a"},
+		{"ingress:\n  host: ${ingress}\n", "ingress.host: reference cycle: ingress -> ingress.host -> ingress"},
+		{"image:\n  tag: x\nref: image-${image}\n", "ref: 'image' can not be part of a string, as it is not a string, number or boolean"},
+		{"empty: ${}\n", "empty: empty reference '${}'"},
+	} {
+		err := templatetools.Interpolate(variables(t, test.data))
+		assert.EqualError(t, err, test.err, test.data)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	vars := variables(t, "host: ${app}.${domain}\nlist: ['${.cluster.name}', '$${literal}']\n")
+	assert.ElementsMatch(t, [][]string{{"app"}, {"domain"}, {"cluster", "name"}}, templatetools.References(vars))
+}
+
+func TestEscapeVariables(t *testing.T) {
+	secret := variables(t, `
+password: a${HOME}b
+escaped: $${x}
+nested:
+  script: ['echo ${HOME}', 'tail $', 1]
+`)
+	templatetools.EscapeVariables(secret)
+
+	vars := variables(t, "password: ${secret.password}\ndsn: user:${secret.password}@db\n")
+	vars["secret"] = secret
+
+	err := templatetools.Interpolate(vars)
+	assert.NoError(t, err)
+	assert.Equal(t, variables(t, `
+password: a${HOME}b
+dsn: user:a${HOME}b@db
+secret:
+  password: a${HOME}b
+  escaped: $${x}
+  nested:
+    script: ['echo ${HOME}', 'tail $', 1]
+`), vars)
+}
@@ -0,0 +1,112 @@
+package templatetools
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// LayersFilename is the file in the variables directory that declares the parent layers of clusters.
+const LayersFilename = "layers.yaml"
+
+// GlobalLayer is the layer that is merged first for every cluster.
+const GlobalLayer = "global"
+
+// Layers maps clusters and layers to their parent layers, in the order they are merged.
+type Layers map[string][]string
+
+type layersFile struct {
+	Layers Layers `yaml:"layers"`
+}
+
+// ReadLayers reads a layers file. A missing file means that clusters only have the global layer as parent.
+func ReadLayers(path string) (Layers, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Layers{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: open file: %s", path, err)
+	}
+
+	file := &layersFile{}
+	err = yaml.UnmarshalStrict(data, file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if file.Layers == nil {
+		return Layers{}, nil
+	}
+
+	for name, parents := range file.Layers {
+		if name == GlobalLayer && len(parents) > 0 {
+			return nil, fmt.Errorf("%s: the %s layer can not have parents", path, GlobalLayer)
+		}
+		for _, parent := range parents {
+			if len(parent) == 0 {
+				return nil, fmt.Errorf("%s: %s: empty layer name", path, name)
+			}
+		}
+	}
+
+	return file.Layers, nil
+}
+
+// IsLayer returns true if the name is the global layer, or a parent of any cluster or layer.
+func (l Layers) IsLayer(name string) bool {
+	if name == GlobalLayer {
+		return true
+	}
+	for _, parents := range l {
+		for _, parent := range parents {
+			if parent == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Resolve returns the layers of a cluster in the order they are merged: the global layer,
+// then every parent before its children, and finally the cluster itself.
+// A layer that is inherited through several parents is only merged once, at its first position.
+func (l Layers) Resolve(name string) ([]string, error) {
+	result := []string{GlobalLayer}
+	if name == GlobalLayer {
+		return result, nil
+	}
+
+	resolved := map[string]bool{GlobalLayer: true}
+	err := l.resolve(name, nil, resolved, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (l Layers) resolve(name string, chain []string, resolved map[string]bool, result *[]string) error {
+	for _, ancestor := range chain {
+		if ancestor == name {
+			return fmt.Errorf("layer cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+	if resolved[name] {
+		return nil
+	}
+
+	chain = append(chain, name)
+	for _, parent := range l[name] {
+		err := l.resolve(parent, chain, resolved, result)
+		if err != nil {
+			return err
+		}
+	}
+
+	resolved[name] = true
+	*result = append(*result, name)
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package templatetools_test
+
+import (
+	"github.com/nais/naisplater/pkg/templatetools"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var layers = templatetools.Layers{
+	"prod-gcp-1": {"prod-gcp"},
+	"prod-gcp-2": {"prod-gcp", "gcp"},
+	"prod-gcp":   {"prod", "gcp"},
+	"dev-gcp":    {"global", "gcp"},
+}
+
+func TestResolve(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		result []string
+	}{
+		{"prod-gcp-1", []string{"global", "prod", "gcp", "prod-gcp", "prod-gcp-1"}},
+		{"prod-gcp-2", []string{"global", "prod", "gcp", "prod-gcp", "prod-gcp-2"}},
+		{"dev-gcp", []string{"global", "gcp", "dev-gcp"}},
+		{"dev-onprem", []string{"global", "dev-onprem"}},
+		{"global", []string{"global"}},
+	} {
+		result, err := layers.Resolve(test.name)
+		assert.NoError(t, err)
+		assert.Equal(t, test.result, result, test.name)
+	}
+}
+
+func TestResolveCycle(t *testing.T) {
+	cyclic := templatetools.Layers{
+		"prod-gcp-1": {"prod-gcp"},
+		"prod-gcp":   {"prod"},
+		"prod":       {"prod-gcp-1"},
+	}
+	_, err := cyclic.Resolve("prod-gcp-1")
+	assert.EqualError(t, err, "layer cycle: prod-gcp-1 -> prod-gcp -> prod -> prod-gcp-1")
+}
+
+func TestIsLayer(t *testing.T) {
+	assert.True(t, layers.IsLayer("global"))
+	assert.True(t, layers.IsLayer("prod"))
+	assert.True(t, layers.IsLayer("prod-gcp"))
+	assert.False(t, layers.IsLayer("prod-gcp-1"))
+}
+
+func TestReadLayers(t *testing.T) {
+	dir := t.TempDir()
+
+	result, err := templatetools.ReadLayers(filepath.Join(dir, templatetools.LayersFilename))
+	assert.NoError(t, err)
+	assert.Empty(t, result)
+
+	path := writeFile(t, dir, templatetools.LayersFilename, "layers:\n  prod-gcp: [prod, gcp]\n")
+	result, err = templatetools.ReadLayers(path)
+	assert.NoError(t, err)
+	assert.Equal(t, templatetools.Layers{"prod-gcp": {"prod", "gcp"}}, result)
+
+	path = writeFile(t, dir, templatetools.LayersFilename, "layers:\n  global: [prod]\n")
+	_, err = templatetools.ReadLayers(path)
+	assert.EqualError(t, err, path+": the global layer can not have parents")
+}
@@ -0,0 +1,347 @@
+package templatetools
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// PolicyFilename is the file in the variables directory with merge rules for all variable files.
+const PolicyFilename = "merge.yaml"
+
+// DefaultMergeKey identifies the maps in lists that are merged with the Merge strategy.
+const DefaultMergeKey = "name"
+
+// Strategy is how a variable is merged with the value it overrides.
+type Strategy string
+
+const (
+	// Replace merges maps, and replaces all other values. It is the default.
+	Replace Strategy = "replace"
+	// Append adds the items of a list after those of the list it overrides.
+	Append Strategy = "append"
+	// Prepend adds the items of a list before those of the list it overrides.
+	Prepend Strategy = "prepend"
+	// Merge merges lists of maps, merging maps with the same value for the merge key into each other.
+	Merge Strategy = "merge"
+	// Delete removes the variable it overrides. It can only be set with a tag.
+	Delete Strategy = "delete"
+)
+
+// Rule is how a variable is merged. Strict rules fail on any change of type, also for the variables within a map.
+type Rule struct {
+	Strategy Strategy `yaml:"strategy"`
+	Key      string   `yaml:"key"`
+	Strict   bool     `yaml:"strict"`
+}
+
+// Rules maps the paths of variables, such as 'ingress.hosts', to their merge rules.
+type Rules map[string]Rule
+
+// tags set merge rules for single variables in variable files, such as 'hosts: !append [...]'.
+var tags = map[string]Rule{
+	"!replace": {Strategy: Replace},
+	"!append":  {Strategy: Append},
+	"!prepend": {Strategy: Prepend},
+	"!merge":   {Strategy: Merge},
+	"!delete":  {Strategy: Delete},
+	"!strict":  {Strict: true},
+}
+
+// Policy holds merge rules for all variable files. A strict policy fails on any change of type.
+// The empty policy merges like MergeMaps.
+type Policy struct {
+	Strict bool  `yaml:"strict"`
+	Rules  Rules `yaml:"rules"`
+}
+
+// ReadPolicy reads a policy file. A missing file is the empty policy.
+func ReadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Policy{Rules: Rules{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: open file: %s", path, err)
+	}
+
+	policy := &Policy{}
+	err = yaml.UnmarshalStrict(data, policy)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if policy.Rules == nil {
+		policy.Rules = Rules{}
+	}
+
+	for key, rule := range policy.Rules {
+		switch rule.Strategy {
+		case "", Replace, Append, Prepend, Merge:
+		case Delete:
+			return nil, fmt.Errorf("%s: %s: strategy '%s' can only be set with a !delete tag", path, key, rule.Strategy)
+		default:
+			return nil, fmt.Errorf("%s: %s: unknown strategy '%s'; use replace, append, prepend or merge", path, key, rule.Strategy)
+		}
+		if len(rule.Key) > 0 && rule.Strategy != Merge {
+			return nil, fmt.Errorf("%s: %s: key can only be set for strategy '%s'", path, key, Merge)
+		}
+	}
+
+	return policy, nil
+}
+
+// ReadVariables reads a variable file, together with the merge rules set by tags in it.
+// Variables tagged with !delete are only in the rules, as they remove the variable they override.
+func ReadVariables(path string) (Variables, Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: open file: %s", path, err)
+	}
+
+	vars := Variables{}
+	err = yaml.Unmarshal(data, &vars)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// tags are not decoded by yaml.v2, so they are read from the document nodes
+	var document yamlv3.Node
+	err = yamlv3.Unmarshal(data, &document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	rules := Rules{}
+	if len(document.Content) > 0 {
+		err = readTags(vars, document.Content[0], nil, rules)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return vars, rules, nil
+}
+
+func readTags(vars Variables, mapping *yamlv3.Node, path []string, rules Rules) error {
+	if mapping.Kind == yamlv3.AliasNode {
+		mapping = mapping.Alias
+	}
+	if mapping.Kind != yamlv3.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		keyNode, valueNode := mapping.Content[i], mapping.Content[i+1]
+		k, ok := mapKey(vars, keyNode.Value)
+		if !ok {
+			continue
+		}
+		if valueNode.Kind == yamlv3.AliasNode {
+			valueNode = valueNode.Alias
+		}
+
+		// rules apply to decrypted variables, which have no '.enc' suffix
+		childPath := append(append([]string{}, path...), strings.TrimSuffix(keyNode.Value, ".enc"))
+		name := strings.Join(childPath, ".")
+
+		if strings.HasPrefix(valueNode.Tag, "!") && !strings.HasPrefix(valueNode.Tag, "!!") {
+			rule, ok := tags[valueNode.Tag]
+			if !ok {
+				return fmt.Errorf("line %d: %s: unknown tag '%s'", valueNode.Line, name, valueNode.Tag)
+			}
+			rules[name] = rule
+
+			if rule.Strategy == Delete {
+				delete(vars, k)
+				continue
+			}
+
+			// yaml.v2 decodes scalars with unknown tags as strings, so plain scalars are decoded again without the tag
+			if valueNode.Kind == yamlv3.ScalarNode && valueNode.Style&^yamlv3.TaggedStyle == 0 {
+				var value interface{}
+				err := yaml.Unmarshal([]byte(valueNode.Value), &value)
+				if err != nil {
+					return fmt.Errorf("line %d: %s: %w", valueNode.Line, name, err)
+				}
+				vars[k] = value
+			}
+		}
+
+		if nested, ok := vars[k].(Variables); ok {
+			err := readTags(nested, valueNode, childPath, rules)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// With returns a policy that also has the rules set by tags in a variable file. These take precedence
+// over the strategies of the policy, while variables are strict if either is.
+func (p *Policy) With(rules Rules) *Policy {
+	result := &Policy{Rules: Rules{}}
+	if p != nil {
+		result.Strict = p.Strict
+		for path, rule := range p.Rules {
+			result.Rules[path] = rule
+		}
+	}
+	for path, rule := range rules {
+		existing := result.Rules[path]
+		if len(rule.Strategy) > 0 {
+			existing.Strategy = rule.Strategy
+			existing.Key = rule.Key
+		}
+		existing.Strict = existing.Strict || rule.Strict
+		result.Rules[path] = existing
+	}
+	return result
+}
+
+// MergeMaps merges src into dst with the rules of the policy.
+func (p *Policy) MergeMaps(dst, src Variables) error {
+	if p == nil {
+		p = &Policy{}
+	}
+	return p.merge(dst, src, nil, p.Strict)
+}
+
+func (p *Policy) merge(dst, src Variables, path []string, strict bool) error {
+	// deleted variables are not in src, only in the rules
+	prefix := strings.Join(path, ".")
+	for rulePath, rule := range p.Rules {
+		if rule.Strategy != Delete {
+			continue
+		}
+		parent, key := splitPath(rulePath)
+		if parent != prefix {
+			continue
+		}
+		if k, ok := mapKey(dst, key); ok {
+			delete(dst, k)
+		}
+	}
+
+	for k, srcValue := range src {
+		childPath := append(append([]string{}, path...), fmt.Sprint(k))
+		name := strings.Join(childPath, ".")
+		rule := p.Rules[name]
+		childStrict := strict || rule.Strict
+
+		dstValue, ok := dst[k]
+		if !ok {
+			dst[k] = srcValue
+			continue
+		}
+
+		if childStrict && kind(dstValue) != kind(srcValue) {
+			return fmt.Errorf("%s: strict: trying to overwrite %s variable with %s", k, kind(dstValue), kind(srcValue))
+		}
+
+		switch rule.Strategy {
+		case Append, Prepend, Merge:
+			dstList, dstOk := dstValue.([]interface{})
+			srcList, srcOk := srcValue.([]interface{})
+			if !dstOk || !srcOk {
+				return fmt.Errorf("%s: strategy '%s' needs lists, got %s and %s", k, rule.Strategy, kind(dstValue), kind(srcValue))
+			}
+			merged, err := p.mergeLists(dstList, srcList, rule, childPath, childStrict)
+			if err != nil {
+				return fmt.Errorf("%s: %s", k, err)
+			}
+			dst[k] = merged
+			continue
+		}
+
+		dstMap, ok := dstValue.(Variables)
+		if !ok {
+			dst[k] = srcValue
+			continue
+		}
+		srcMap, ok := srcValue.(Variables)
+		if !ok {
+			return fmt.Errorf("%s: trying to overwrite map variable with non-map type variable", k)
+		}
+		err := p.merge(dstMap, srcMap, childPath, childStrict)
+		if err != nil {
+			return fmt.Errorf("%s: %s", k, err)
+		}
+	}
+
+	return nil
+}
+
+// mergeLists merges two lists with the append, prepend or merge strategy.
+func (p *Policy) mergeLists(dst, src []interface{}, rule Rule, path []string, strict bool) ([]interface{}, error) {
+	switch rule.Strategy {
+	case Append:
+		return append(append([]interface{}{}, dst...), src...), nil
+	case Prepend:
+		return append(append([]interface{}{}, src...), dst...), nil
+	}
+
+	key := rule.Key
+	if len(key) == 0 {
+		key = DefaultMergeKey
+	}
+
+	result := append([]interface{}{}, dst...)
+	for i, item := range src {
+		srcMap, ok := item.(Variables)
+		if !ok || srcMap[key] == nil {
+			return nil, fmt.Errorf("item %d has no '%s' to merge by", i, key)
+		}
+		merged := false
+		for _, existing := range result {
+			dstMap, ok := existing.(Variables)
+			if !ok || dstMap[key] == nil || fmt.Sprint(dstMap[key]) != fmt.Sprint(srcMap[key]) {
+				continue
+			}
+			// variables within the items have the path of the list, so that rules such as 'containers.env' apply
+			err := p.merge(dstMap, srcMap, path, strict)
+			if err != nil {
+				return nil, fmt.Errorf("%s=%v: %s", key, srcMap[key], err)
+			}
+			merged = true
+			break
+		}
+		if !merged {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// splitPath splits a path such as 'a.b.c' into its parent 'a.b' and key 'c'.
+func splitPath(path string) (string, string) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
+
+// kind returns the kind of a variable, for error messages and strict type checks.
+func kind(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case Variables, map[interface{}]interface{}:
+		return "map"
+	case []interface{}:
+		return "list"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64, uint64, float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,159 @@
+package templatetools_test
+
+import (
+	"github.com/nais/naisplater/pkg/templatetools"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const globalMergeVariables = `hosts: [a.example.com]
+debug:
+  enabled: true
+replicas: 1
+containers:
+  - name: app
+    image: app:1
+  - name: proxy
+    image: proxy:1
+`
+
+func TestMergeMaps(t *testing.T) {
+	dst := variables(t, globalMergeVariables)
+	err := templatetools.MergeMaps(dst, variables(t, "hosts: [b.example.com]\nreplicas: three\ndebug:\n  level: 2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"b.example.com"}, dst["hosts"])
+	assert.Equal(t, "three", dst["replicas"])
+	assert.Equal(t, templatetools.Variables{"enabled": true, "level": 2}, dst["debug"])
+
+	err = templatetools.MergeMaps(dst, variables(t, "debug: false\n"))
+	assert.EqualError(t, err, "debug: trying to overwrite map variable with non-map type variable")
+}
+
+func TestPolicyMergeMaps(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "prod.yaml", `hosts: !prepend [b.example.com]
+debug: !delete
+replicas: !strict 3
+containers: !merge
+  - name: app
+    image: app:2
+  - name: sidecar
+    image: sidecar:1
+`)
+	vars, rules, err := templatetools.ReadVariables(path)
+	assert.NoError(t, err)
+
+	dst := variables(t, globalMergeVariables)
+	err = templatetools.MergeMaps(dst, variables(t, "debug:\n  level: 2\n"))
+	assert.NoError(t, err)
+
+	err = (&templatetools.Policy{}).With(rules).MergeMaps(dst, vars)
+	assert.NoError(t, err)
+	assert.Equal(t, variables(t, `hosts: [b.example.com, a.example.com]
+replicas: 3
+containers:
+  - name: app
+    image: app:2
+  - name: proxy
+    image: proxy:1
+  - name: sidecar
+    image: sidecar:1
+`), dst)
+}
+
+func TestPolicyMergeMapsRules(t *testing.T) {
+	policy := &templatetools.Policy{Rules: templatetools.Rules{
+		"hosts":      {Strategy: templatetools.Append},
+		"containers": {Strategy: templatetools.Merge, Key: "image"},
+	}}
+
+	dst := variables(t, globalMergeVariables)
+	err := policy.MergeMaps(dst, variables(t, "hosts: [b.example.com]\ncontainers:\n  - image: app:1\n    port: 8080\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a.example.com", "b.example.com"}, dst["hosts"])
+	assert.Equal(t, templatetools.Variables{"name": "app", "image": "app:1", "port": 8080}, dst["containers"].([]interface{})[0])
+
+	// rules set by tags take precedence over those of the policy
+	err = policy.With(templatetools.Rules{"hosts": {Strategy: templatetools.Replace}}).MergeMaps(dst, variables(t, "hosts: [c.example.com]\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"c.example.com"}, dst["hosts"])
+}
+
+func TestPolicyMergeMapsErrors(t *testing.T) {
+	strict := &templatetools.Policy{Strict: true}
+	for _, test := range []struct {
+		policy *templatetools.Policy
+		data   string
+		err    string
+	}{
+		{strict, "replicas: three\n", "replicas: strict: trying to overwrite number variable with string"},
+		{strict, "hosts:\n  a: a.example.com\n", "hosts: strict: trying to overwrite list variable with map"},
+		{strict.With(templatetools.Rules{"hosts": {Strategy: templatetools.Append}}), "hosts: a.example.com\n", "hosts: strict: trying to overwrite list variable with string"},
+		{(&templatetools.Policy{}).With(templatetools.Rules{"debug": {Strict: true}}), "debug:\n  enabled: yes please\n", "debug: enabled: strict: trying to overwrite boolean variable with string"},
+		{(&templatetools.Policy{}).With(templatetools.Rules{"replicas": {Strategy: templatetools.Append}}), "replicas: [2]\n", "replicas: strategy 'append' needs lists, got number and list"},
+		{(&templatetools.Policy{}).With(templatetools.Rules{"containers": {Strategy: templatetools.Merge}}), "containers:\n  - image: app:2\n", "containers: item 0 has no 'name' to merge by"},
+	} {
+		err := test.policy.MergeMaps(variables(t, globalMergeVariables), variables(t, test.data))
+		assert.EqualError(t, err, test.err, test.data)
+	}
+}
+
+func TestReadVariables(t *testing.T) {
+	dir := t.TempDir()
+	path := writeFile(t, dir, "prod.yaml", `replicas: !strict 3
+enabled: !replace true
+empty: !strict
+name: !strict "3"
+image:
+  tag: !delete
+secret.enc: !strict ciphertext
+`)
+	vars, rules, err := templatetools.ReadVariables(path)
+	assert.NoError(t, err)
+	assert.Equal(t, templatetools.Variables{
+		"replicas":   3,
+		"enabled":    true,
+		"empty":      nil,
+		"name":       "3",
+		"image":      templatetools.Variables{},
+		"secret.enc": "ciphertext",
+	}, vars)
+	assert.Equal(t, templatetools.Rules{
+		"replicas":  {Strict: true},
+		"enabled":   {Strategy: templatetools.Replace},
+		"empty":     {Strict: true},
+		"name":      {Strict: true},
+		"image.tag": {Strategy: templatetools.Delete},
+		"secret":    {Strict: true},
+	}, rules)
+
+	path = writeFile(t, dir, "dev.yaml", "hosts: !apend [a.example.com]\n")
+	_, _, err = templatetools.ReadVariables(path)
+	assert.EqualError(t, err, path+": line 1: hosts: unknown tag '!apend'")
+}
+
+func TestReadPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	policy, err := templatetools.ReadPolicy(writeFile(t, dir, "merge.yaml", "strict: true\nrules:\n  containers:\n    strategy: merge\n    key: image\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, &templatetools.Policy{
+		Strict: true,
+		Rules:  templatetools.Rules{"containers": {Strategy: templatetools.Merge, Key: "image"}},
+	}, policy)
+
+	policy, err = templatetools.ReadPolicy(dir + "/missing.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, &templatetools.Policy{Rules: templatetools.Rules{}}, policy)
+
+	for data, message := range map[string]string{
+		"rules:\n  hosts:\n    strategy: remove\n":             "hosts: unknown strategy 'remove'; use replace, append, prepend or merge",
+		"rules:\n  hosts:\n    strategy: delete\n":             "hosts: strategy 'delete' can only be set with a !delete tag",
+		"rules:\n  hosts:\n    strategy: append\n    key: x\n": "hosts: key can only be set for strategy 'merge'",
+	} {
+		path := writeFile(t, dir, "merge.yaml", data)
+		_, err = templatetools.ReadPolicy(path)
+		assert.EqualError(t, err, path+": "+message)
+	}
+}
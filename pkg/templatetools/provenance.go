@@ -0,0 +1,299 @@
+package templatetools
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Origin is a value that a variable file, or the command line, sets for a variable.
+// Encrypted values are set with a 'key.enc' key, and are not decrypted.
+// Strategy is set if a tag in the file sets how the value is merged. Deleted variables have no value.
+type Origin struct {
+	File      string
+	Line      int
+	Value     interface{}
+	Encrypted bool
+	Strategy  Strategy
+}
+
+// Variable is a variable with all values set for it, in the order they are merged. The last value is in effect,
+// and overrides the others.
+type Variable struct {
+	Path    []string
+	Origins []Origin
+}
+
+// Provenance records where each variable is set, merging variables like MergeMaps does.
+type Provenance struct {
+	root *provenanceNode
+}
+
+type provenanceNode struct {
+	origins  []Origin
+	children map[string]*provenanceNode
+	deleted  bool
+}
+
+// NewProvenance returns an empty provenance.
+func NewProvenance() *Provenance {
+	return &Provenance{
+		root: &provenanceNode{children: make(map[string]*provenanceNode)},
+	}
+}
+
+// AddFile merges the variables of a file.
+func (p *Provenance) AddFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: open file: %s", path, err)
+	}
+
+	var values yaml.MapSlice
+	err = yaml.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	var document yamlv3.Node
+	err = yamlv3.Unmarshal(data, &document)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+
+	err = p.root.merge(path, values, document.Content[0])
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (n *provenanceNode) merge(file string, values yaml.MapSlice, mapping *yamlv3.Node) error {
+	for i, item := range values {
+		key := fmt.Sprint(item.Key)
+		line := keyLine(mapping, i, len(values), key)
+		value := valueNode(mapping, i, len(values), key)
+
+		// Encrypted values are renamed like CryptTransform does when decrypting.
+		_, isString := item.Value.(string)
+		encrypted := isString && strings.HasSuffix(key, ".enc")
+		if encrypted {
+			key = strings.TrimSuffix(key, ".enc")
+		}
+
+		child, ok := n.children[key]
+		if !ok {
+			child = &provenanceNode{}
+			n.children[key] = child
+		}
+
+		var strategy Strategy
+		if value != nil {
+			strategy = tags[value.Tag].Strategy
+		}
+		if strategy == Delete {
+			child.delete(Origin{File: file, Line: line, Strategy: Delete})
+			continue
+		}
+
+		if nested, ok := item.Value.(yaml.MapSlice); ok {
+			if child.children == nil {
+				child.children = make(map[string]*provenanceNode)
+			}
+			child.deleted = false
+			err := child.merge(file, nested, value)
+			if err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			continue
+		}
+
+		err := child.set(key, Origin{
+			File:      file,
+			Line:      line,
+			Value:     plainValue(item.Value),
+			Encrypted: encrypted,
+			Strategy:  strategy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddVariables merges variables that are not read from a file, such as variables set on the command line.
+// Their origins have no line.
+func (p *Provenance) AddVariables(source string, vars Variables) error {
+	err := p.root.mergeVariables(source, vars)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return nil
+}
+
+func (n *provenanceNode) mergeVariables(source string, vars Variables) error {
+	for k, value := range vars {
+		key := fmt.Sprint(k)
+		child, ok := n.children[key]
+		if !ok {
+			child = &provenanceNode{}
+			n.children[key] = child
+		}
+
+		if nested, ok := value.(Variables); ok {
+			if child.children == nil {
+				child.children = make(map[string]*provenanceNode)
+			}
+			err := child.mergeVariables(source, nested)
+			if err != nil {
+				return fmt.Errorf("%s: %s", key, err)
+			}
+			continue
+		}
+
+		err := child.set(key, Origin{
+			File:  source,
+			Value: value,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// set adds a value that is not a map. A deleted map can be replaced by any value.
+func (n *provenanceNode) set(key string, origin Origin) error {
+	if n.children != nil {
+		if !n.deleted {
+			return fmt.Errorf("%s: trying to overwrite map variable with non-map type variable", key)
+		}
+		n.children = nil
+	}
+	n.deleted = false
+	n.origins = append(n.origins, origin)
+	return nil
+}
+
+// delete records that a variable, or all variables of a map, are deleted.
+func (n *provenanceNode) delete(origin Origin) {
+	n.deleted = true
+	if n.children == nil {
+		n.origins = append(n.origins, origin)
+		return
+	}
+	for _, child := range n.children {
+		child.delete(origin)
+	}
+}
+
+// keyNode returns the node of the i'th of count keys in a mapping. Keys are matched by position,
+// unless merge keys make the mapping differ from the decoded values.
+func keyNode(mapping *yamlv3.Node, i, count int, key string) *yamlv3.Node {
+	if mapping == nil {
+		return nil
+	}
+	if mapping.Kind == yamlv3.AliasNode {
+		mapping = mapping.Alias
+	}
+	if len(mapping.Content) == 2*count {
+		return mapping.Content[2*i]
+	}
+	for j := 0; j+1 < len(mapping.Content); j += 2 {
+		if mapping.Content[j].Value == key {
+			return mapping.Content[j]
+		}
+	}
+	return nil
+}
+
+func keyLine(mapping *yamlv3.Node, i, count int, key string) int {
+	node := keyNode(mapping, i, count, key)
+	if node == nil {
+		if mapping == nil {
+			return 0
+		}
+		return mapping.Line
+	}
+	return node.Line
+}
+
+func valueNode(mapping *yamlv3.Node, i, count int, key string) *yamlv3.Node {
+	node := keyNode(mapping, i, count, key)
+	if node == nil {
+		return nil
+	}
+	if mapping.Kind == yamlv3.AliasNode {
+		mapping = mapping.Alias
+	}
+	for j := 0; j+1 < len(mapping.Content); j += 2 {
+		if mapping.Content[j] == node {
+			value := mapping.Content[j+1]
+			if value.Kind == yamlv3.AliasNode {
+				value = value.Alias
+			}
+			return value
+		}
+	}
+	return nil
+}
+
+// plainValue converts ordered maps into regular maps.
+func plainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		result := make(map[string]interface{}, len(v))
+		for _, item := range v {
+			result[fmt.Sprint(item.Key)] = plainValue(item.Value)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i := range v {
+			result[i] = plainValue(v[i])
+		}
+		return result
+	}
+	return value
+}
+
+// Variables returns all variables at or below a path, sorted by path.
+// Maps are descended into, while all other values, including lists, are variables.
+// It returns false if the path is not set.
+func (p *Provenance) Variables(path ...string) ([]Variable, bool) {
+	n := p.root
+	for _, key := range path {
+		child, ok := n.children[key]
+		if !ok {
+			return nil, false
+		}
+		n = child
+	}
+
+	result := make([]Variable, 0)
+	n.collect(append([]string{}, path...), &result)
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Join(result[i].Path, ".") < strings.Join(result[j].Path, ".")
+	})
+	return result, true
+}
+
+func (n *provenanceNode) collect(path []string, result *[]Variable) {
+	if n.children == nil {
+		*result = append(*result, Variable{Path: path, Origins: n.origins})
+		return
+	}
+	for key, child := range n.children {
+		child.collect(append(append([]string{}, path...), key), result)
+	}
+}
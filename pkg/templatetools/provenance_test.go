@@ -0,0 +1,139 @@
+package templatetools_test
+
+import (
+	"github.com/nais/naisplater/pkg/templatetools"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const globalVariables = `clusterName: none
+image:
+  repository: nginx
+  tag: "1.19"
+secret.enc: ciphertext
+ports: [80]
+`
+
+const clusterVariables = `clusterName: prod
+image:
+  tag: "1.21"
+ports: [443]
+`
+
+func writeFile(t *testing.T, dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, []byte(data), 0644)
+	assert.NoError(t, err)
+	return path
+}
+
+func provenance(t *testing.T) (*templatetools.Provenance, string, string) {
+	dir := t.TempDir()
+	global := writeFile(t, dir, "global.yaml", globalVariables)
+	cluster := writeFile(t, dir, "prod.yaml", clusterVariables)
+
+	p := templatetools.NewProvenance()
+	assert.NoError(t, p.AddFile(global))
+	assert.NoError(t, p.AddFile(cluster))
+	return p, global, cluster
+}
+
+func TestProvenance(t *testing.T) {
+	p, global, cluster := provenance(t)
+
+	variables, ok := p.Variables("image")
+	assert.True(t, ok)
+	assert.Equal(t, []templatetools.Variable{
+		{
+			Path:    []string{"image", "repository"},
+			Origins: []templatetools.Origin{{File: global, Line: 3, Value: "nginx"}},
+		},
+		{
+			Path: []string{"image", "tag"},
+			Origins: []templatetools.Origin{
+				{File: global, Line: 4, Value: "1.19"},
+				{File: cluster, Line: 3, Value: "1.21"},
+			},
+		},
+	}, variables)
+
+	variables, ok = p.Variables("ports")
+	assert.True(t, ok)
+	assert.Equal(t, []interface{}{443}, variables[0].Origins[1].Value)
+
+	variables, ok = p.Variables("secret")
+	assert.True(t, ok)
+	assert.Equal(t, []templatetools.Origin{{File: global, Line: 5, Value: "ciphertext", Encrypted: true}}, variables[0].Origins)
+
+	_, ok = p.Variables("image", "digest")
+	assert.False(t, ok)
+}
+
+func TestProvenanceAll(t *testing.T) {
+	p, _, _ := provenance(t)
+
+	variables, ok := p.Variables()
+	assert.True(t, ok)
+
+	paths := make([][]string, 0)
+	for _, variable := range variables {
+		paths = append(paths, variable.Path)
+	}
+	assert.Equal(t, [][]string{{"clusterName"}, {"image", "repository"}, {"image", "tag"}, {"ports"}, {"secret"}}, paths)
+}
+
+func TestProvenanceOverwriteMap(t *testing.T) {
+	dir := t.TempDir()
+	p := templatetools.NewProvenance()
+	assert.NoError(t, p.AddFile(writeFile(t, dir, "global.yaml", globalVariables)))
+
+	path := writeFile(t, dir, "prod.yaml", "image: nginx:1.21\n")
+	err := p.AddFile(path)
+	assert.EqualError(t, err, path+": image: trying to overwrite map variable with non-map type variable")
+}
+
+func TestProvenanceAddVariables(t *testing.T) {
+	p, _, cluster := provenance(t)
+
+	err := p.AddVariables("--set image.tag", templatetools.Variables{
+		"image": templatetools.Variables{"tag": "1.22"},
+	})
+	assert.NoError(t, err)
+
+	variables, ok := p.Variables("image", "tag")
+	assert.True(t, ok)
+	assert.Equal(t, []templatetools.Origin{
+		{File: cluster, Line: 3, Value: "1.21"},
+		{File: "--set image.tag", Value: "1.22"},
+	}, variables[0].Origins[1:])
+
+	err = p.AddVariables("--set image", templatetools.Variables{"image": "nginx:1.22"})
+	assert.EqualError(t, err, "--set image: image: trying to overwrite map variable with non-map type variable")
+}
+
+func TestProvenanceDelete(t *testing.T) {
+	p, global, cluster := provenance(t)
+
+	dir := t.TempDir()
+	path := writeFile(t, dir, "prod-gcp.yaml", "image: !delete\nports: !append [8080]\n")
+	assert.NoError(t, p.AddFile(path))
+
+	variables, ok := p.Variables("image", "tag")
+	assert.True(t, ok)
+	assert.Equal(t, []templatetools.Origin{
+		{File: global, Line: 4, Value: "1.19"},
+		{File: cluster, Line: 3, Value: "1.21"},
+		{File: path, Line: 1, Strategy: templatetools.Delete},
+	}, variables[0].Origins)
+
+	variables, ok = p.Variables("ports")
+	assert.True(t, ok)
+	assert.Equal(t, templatetools.Origin{File: path, Line: 2, Value: []interface{}{8080}, Strategy: templatetools.Append}, variables[0].Origins[2])
+
+	// a deleted map can be set to any value
+	err := p.AddVariables("--set image", templatetools.Variables{"image": "nginx:1.22"})
+	assert.NoError(t, err)
+}
@@ -3,8 +3,7 @@ package templatetools
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
+	"sort"
 	"strings"
 )
 
@@ -44,47 +43,23 @@ func CryptTransform(vars Variables, password string, fn CryptFunc, translate boo
 	return nil
 }
 
+// MergeMaps merges src into dst. Maps are merged, while all other values in src replace those in dst.
+// Use a Policy for other strategies.
 func MergeMaps(dst, src Variables) error {
-	for k, srcValue := range src {
-		dstValue, ok := dst[k]
-		if !ok {
-			dst[k] = srcValue
-			continue
-		}
-		dstMap, ok := dstValue.(Variables)
-		if !ok {
-			dst[k] = srcValue
-			continue
-		}
-		srcMap, ok := srcValue.(Variables)
-		if !ok {
-			return fmt.Errorf("%s: trying to overwrite map variable with non-map type variable", k)
-		}
-		err := MergeMaps(dstMap, srcMap)
-		if err != nil {
-			return fmt.Errorf("%s: %s", k, err)
-		}
-	}
-
-	return nil
+	return (&Policy{}).MergeMaps(dst, src)
 }
 
+// VariablesFromFiles reads and merges variable files, with the merge rules set by tags in the files.
 func VariablesFromFiles(paths ...string) (Variables, error) {
 	allVars := Variables{}
-	vars := Variables{}
 
 	for _, path := range paths {
-		file, err := ioutil.ReadFile(path)
-		if err != nil {
-			return nil, fmt.Errorf("%s: open file: %s", path, err)
-		}
-
-		err = yaml.Unmarshal(file, &vars)
+		vars, rules, err := ReadVariables(path)
 		if err != nil {
 			return nil, err
 		}
 
-		err = MergeMaps(allVars, vars)
+		err = (&Policy{}).With(rules).MergeMaps(allVars, vars)
 		if err != nil {
 			return nil, fmt.Errorf("%s: %w", path, err)
 		}
@@ -92,3 +67,53 @@ func VariablesFromFiles(paths ...string) (Variables, error) {
 
 	return allVars, nil
 }
+
+// Copy returns a deep copy of a variable tree, so that it can be merged
+// into without affecting the original.
+func Copy(vars Variables) Variables {
+	result := make(Variables, len(vars))
+	for k, v := range vars {
+		result[k] = copyValue(v)
+	}
+	return result
+}
+
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case Variables:
+		return Copy(typed)
+	case map[interface{}]interface{}:
+		return map[interface{}]interface{}(Copy(typed))
+	case []interface{}:
+		result := make([]interface{}, len(typed))
+		for i := range typed {
+			result[i] = copyValue(typed[i])
+		}
+		return result
+	default:
+		return value
+	}
+}
+
+// Conflicts returns the paths of variables that are set in both a and b, sorted.
+// Maps that are set in both are only conflicts if they have variables in common.
+func Conflicts(a, b Variables) []string {
+	conflicts := make([]string, 0)
+	for k, bValue := range b {
+		aValue, ok := a[k]
+		if !ok {
+			continue
+		}
+		aMap, aIsMap := aValue.(Variables)
+		bMap, bIsMap := bValue.(Variables)
+		if aIsMap && bIsMap {
+			for _, conflict := range Conflicts(aMap, bMap) {
+				conflicts = append(conflicts, fmt.Sprintf("%v.%s", k, conflict))
+			}
+			continue
+		}
+		conflicts = append(conflicts, fmt.Sprint(k))
+	}
+	sort.Strings(conflicts)
+	return conflicts
+}
@@ -0,0 +1,28 @@
+package templatetools_test
+
+import (
+	"github.com/nais/naisplater/pkg/templatetools"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflicts(t *testing.T) {
+	a := templatetools.Variables{
+		"team": "aura",
+		"image": templatetools.Variables{
+			"repository": "nginx",
+			"tag":        "1.19",
+		},
+		"ports": []interface{}{80},
+	}
+	b := templatetools.Variables{
+		"image": templatetools.Variables{
+			"tag": "1.21",
+		},
+		"ports":   []interface{}{443},
+		"ingress": true,
+	}
+	assert.Equal(t, []string{"image.tag", "ports"}, templatetools.Conflicts(a, b))
+	assert.Empty(t, templatetools.Conflicts(a, templatetools.Variables{"image": templatetools.Variables{"digest": "sha256"}}))
+}